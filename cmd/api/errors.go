@@ -124,6 +124,35 @@ func (appPtr *application) editConflictResponse(w http.ResponseWriter, r *http.R
 	appPtr.errorResponse(w, r, http.StatusConflict, "trying to update a changed or deleted movie - try again!")
 }
 
+/*********************************************************************************************************************/
+/*
+PRECONDITION FAILED RESPONSE
+writes a 412 to a client whose If-Match header names a version of the movie that is no longer the current one, the
+conditional request equivalent of the edit conflict response above.
+*/
+func (appPtr *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	appPtr.errorResponse(w, r, http.StatusPreconditionFailed, "the movie has changed since the version in If-Match - fetch it again and retry")
+}
+
+/*********************************************************************************************************************/
+/*
+DUPLICATE MOVIE RESPONSE
+writes a 409 to a client creating a movie we most likely already have, along with the movies it looks like so that the
+client can use one of them, or send the movie again with ?allow_duplicate=true if it really is another movie. The
+movies are passed in already presented, see presentMovies.
+*/
+func (appPtr *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request, duplicates []any) {
+	env := envelope{
+		"error":      "a movie with this title and year already exists - use ?allow_duplicate=true if this is another movie",
+		"duplicates": duplicates,
+	}
+	err := appPtr.writeJSON(w, http.StatusConflict, env, nil)
+	if err != nil {
+		appPtr.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 /*********************************************************************************************************************/
 /*
 GLOBAL RATE LIMIT EXCEEDED RESPONSE
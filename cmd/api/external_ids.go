@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/*********************************************************************************************************************/
+//GET /v1/movies/lookup?source=imdb&id=tt0111161
+//To find a movie by the id another movie database knows it by, for partners who key their movies that way. The
+//response is a 303 See Other to GET /v1/movies/:id for the movie, so the client gets exactly what that would give
+func (appPtr *application) lookupMovieHandler(w http.ResponseWriter, r *http.Request) {
+	queryString := r.URL.Query()
+	source := appPtr.readString(queryString, "source", "")
+	externalID := appPtr.readString(queryString, "id", "")
+
+	queryValidatorPtr := validator.New()
+	queryValidatorPtr.Check(
+		validator.PermittedValue(source, data.ExternalIDSources...),
+		"source",
+		fmt.Sprintf("must be one of %s", strings.Join(data.ExternalIDSources, ", ")),
+	)
+	queryValidatorPtr.Check(externalID != "", "id", "must be provided")
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	// an id that can't be in the source is no movie of ours, as a 404 like any other
+	movieIDs, err := appPtr.dbModel.MovieExternalIDModel.FindMovieIDs(map[string]string{source: externalID})
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(movieIDs) == 0 {
+		appPtr.notFoundHandler(w, r)
+		return
+	}
+
+	// the rest of the query string (fields, include, runtime_format...) is for GET /v1/movies/:id, which also answers
+	// If-None-Match and 404s a movie in the trash, it keeps its external ids but isn't found until it is restored
+	queryString.Del("source")
+	queryString.Del("id")
+	location := fmt.Sprintf("/v1/movies/%d", movieIDs[0])
+	if len(queryString) > 0 {
+		location += "?" + queryString.Encode()
+	}
+	headers := http.Header{}
+	headers.Set("Location", location)
+	err = appPtr.writeJSON(w, http.StatusSeeOther, envelope{"location": location}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PUT /v1/movies/:id/external_ids/:source
+//To set the id a movie has in another movie database e.g. PUT /v1/movies/1/external_ids/imdb {"id": "tt0111161"},
+//replacing the one it had there before. Read notes(1) in internal/data/external_ids.go
+func (appPtr *application) putMovieExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ID string `json:"id"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	source := httprouter.ParamsFromContext(r.Context()).ByName("source")
+	if !validator.PermittedValue(source, data.ExternalIDSources...) {
+		appPtr.notFoundHandler(w, r)
+		return
+	}
+
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	externalIDValidatorPtr := validator.New()
+	if data.ValidateExternalIDs(externalIDValidatorPtr, map[string]string{source: input.ID}); !externalIDValidatorPtr.Valid() {
+		// the error is keyed by source, but the client sent the id as "id"
+		appPtr.failedValidationResponse(w, r, map[string]string{"id": externalIDValidatorPtr.Errors[source]})
+		return
+	}
+
+	created, err := appPtr.dbModel.MovieExternalIDModel.SetExternalID(moviePtr.ID, source, input.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			externalIDValidatorPtr.AddError("id", "is already the id of another movie")
+			appPtr.failedValidationResponse(w, r, externalIDValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	headers := http.Header{}
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d/external_ids/%s", moviePtr.ID, source))
+	}
+
+	err = appPtr.writeJSON(w, status, envelope{"external_id": envelope{"movie_id": moviePtr.ID, "source": source, "id": input.ID}}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies/:id/external_ids/:source
+//To remove the id a movie has in another movie database
+func (appPtr *application) deleteMovieExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	source := httprouter.ParamsFromContext(r.Context()).ByName("source")
+	if !validator.PermittedValue(source, data.ExternalIDSources...) {
+		appPtr.notFoundHandler(w, r)
+		return
+	}
+
+	err = appPtr.dbModel.MovieExternalIDModel.DeleteExternalID(movieID, source)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "external id successfully deleted"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+)
+
+/*********************************************************************************************************************/
+//GET /v1/genres
+//To list the genres movies can be tagged with, ?include_retired=true also lists the retired ones
+func (appPtr *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	queryValidatorPtr := validator.New()
+	includeRetired := appPtr.readBool(r.URL.Query(), "include_retired", queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	genrePtrs, err := appPtr.dbModel.GenreModel.GetAllGenres(includeRetired != nil && *includeRetired)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"genres": genrePtrs}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//POST /v1/genres
+//To add a new genre
+func (appPtr *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := data.Genre{Name: input.Name}
+
+	genreValidatorPtr := validator.New()
+	data.ValidateGenre(genreValidatorPtr, &genre)
+	if !genreValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, genreValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.GenreModel.InsertGenre(&genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			genreValidatorPtr.AddError("name", "a genre with this name already exists")
+			appPtr.failedValidationResponse(w, r, genreValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PATCH /v1/genres/:id
+//To rename a genre (which renames it on every movie tagged with it) and/or retire or reinstate it
+func (appPtr *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	//pointers so we can tell the fields the client didn't send apart, see notes(4) in movies.go
+	var input struct {
+		Name    *string `json:"name"`
+		Retired *bool   `json:"retired"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	genrePtr, err := appPtr.dbModel.GenreModel.GetGenre(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldName := genrePtr.Name
+	if input.Name != nil {
+		genrePtr.Name = *input.Name
+	}
+	if input.Retired != nil {
+		genrePtr.Retired = *input.Retired
+	}
+
+	genreValidatorPtr := validator.New()
+	data.ValidateGenre(genreValidatorPtr, genrePtr)
+	if !genreValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, genreValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.GenreModel.UpdateGenre(genrePtr, oldName, appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateGenre):
+			genreValidatorPtr.AddError("name", "a genre with this name already exists")
+			appPtr.failedValidationResponse(w, r, genreValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"genre": *genrePtr}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,8 +12,10 @@ import (
 	"net/http"
 	"net/url"
 	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -62,6 +66,245 @@ func (appPtr *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+/*********************************************************************************************************************/
+// RETRIEVE THE MOVIE FOR THE ID URL PARAMETER
+// readMovieParam reads the movie id from the url and fetches the movie, sending the client the
+// appropriate error response if it can't. It returns false if a response has already been sent.
+// Handlers for the resources nested under a movie (reviews, credits...) use this.
+func (appPtr *application) readMovieParam(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return nil, false
+	}
+
+	moviePtr, err := appPtr.dbModel.MovieModel.GetMovie(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return moviePtr, true
+}
+
+/*********************************************************************************************************************/
+// CONDITIONAL REQUESTS
+// movieETag returns the entity tag of a movie, which is simply its version in quotes e.g. "3"
+func movieETag(moviePtr *data.Movie) string {
+	return strconv.Quote(strconv.FormatInt(int64(moviePtr.Version), 10))
+}
+
+// movieRepresentationETag returns the entity tag of a response that shows a movie along with what isn't versioned
+// with it (its translated title, images, external ids...): the movie's version followed by a digest of the response
+// e.g. "3-9f86d081884c7d65", so that it changes whenever any of it does and differs between the languages, fields
+// and formats a movie is shown in. Read notes(6) in movies.go
+func movieRepresentationETag(moviePtr *data.Movie, wrappedData envelope) (string, error) {
+	representation, err := json.Marshal(wrappedData)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(representation)
+	return strconv.Quote(fmt.Sprintf("%d-%s", moviePtr.Version, hex.EncodeToString(digest[:8]))), nil
+}
+
+// etagMatches reports whether etag is in the comma separated list of entity tags of an If-Match or an
+// If-None-Match header, "*" matches any tag. If-None-Match compares weakly (W/"3" matches "3"), which
+// is what weak is for, while If-Match only ever matches strong tags.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchFails reports whether the request has an If-Match header that the movie's current ETag doesn't
+// satisfy, in which case the client should get a 412 Precondition Failed. An edit is conditional on the version
+// alone, so the ETag of GET /v1/movies/:id (see movieRepresentationETag) is as good as the bare version: "3-9f86..."
+// is taken for "3"
+func (appPtr *application) ifMatchFails(r *http.Request, moviePtr *data.Movie) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return false
+	}
+	candidates := strings.Split(ifMatch, ",")
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if version, _, found := strings.Cut(candidate, "-"); found && strings.HasPrefix(candidate, `"`) {
+			candidate = version + `"`
+		}
+		candidates[i] = candidate
+	}
+	return !etagMatches(strings.Join(candidates, ","), movieETag(moviePtr), false)
+}
+
+// ifMatchVersion returns the version of the movie a request that passed ifMatchFails is conditional on, for the
+// model to check again when it writes, or 0 when it isn't conditional (no If-Match, or If-Match: *)
+func ifMatchVersion(r *http.Request, moviePtr *data.Movie) int32 {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0
+	}
+	return moviePtr.Version
+}
+
+/*********************************************************************************************************************/
+// ACCEPT-LANGUAGE
+// acceptedLanguages reads the languages the client prefers from the Accept-Language header, best first and in their
+// canonical form e.g. "fr-CH, fr;q=0.9, *;q=0.5" -> ["fr-CH", "fr"]. The wildcard, languages the client refuses
+// (q=0) and tags that aren't well formed are left out. We only look at the first 10 languages, nobody reads more.
+func acceptedLanguages(r *http.Request) []string {
+	type preference struct {
+		language string
+		quality  float64
+	}
+	preferences := []preference{}
+
+	for _, item := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		language, ok := data.CanonicalLanguageTag(strings.TrimSpace(tag))
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			preferences = append(preferences, preference{language, quality})
+		}
+		if len(preferences) == 10 {
+			break
+		}
+	}
+
+	// languages of equal quality keep the order the client sent them in
+	sort.SliceStable(preferences, func(i, j int) bool { return preferences[i].quality > preferences[j].quality })
+	languages := make([]string, len(preferences))
+	for i, preference := range preferences {
+		languages[i] = preference.language
+	}
+	return languages
+}
+
+/*********************************************************************************************************************/
+// SPARSE FIELDSETS
+// pickFields returns the JSON object of a record with only the given fields in it, for ?fields=id,title. With no
+// fields the record is returned as it is. Fields the record leaves out of its JSON (e.g. an empty highlight) are
+// simply not there.
+func pickFields(record any, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return record, nil
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(recordJSON, &object); err != nil {
+		return nil, err
+	}
+
+	picked := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, exists := object[field]; exists {
+			picked[field] = value
+		}
+	}
+	return picked, nil
+}
+
+// presentMovie is pickFields for a movie, with its runtime written in the format the client asked for with
+// ?runtime_format. Read notes(2) in internal/data/runtime.go
+func presentMovie(movie data.Movie, fields []string, runtimeFormat data.RuntimeFormat) (any, error) {
+	if runtimeFormat == data.RuntimeFormatHuman {
+		return pickFields(movie, fields)
+	}
+	// the runtime of the outer struct hides that of the embedded movie when it is marshalled
+	formattedMovie := struct {
+		data.Movie
+		Runtime any `json:"runtime,omitempty"`
+	}{movie, movie.Runtime.Format(runtimeFormat)}
+	return pickFields(formattedMovie, fields)
+}
+
+// presentMovies is presentMovie for a whole listing, keeping every field
+func presentMovies(moviePtrs []*data.Movie, runtimeFormat data.RuntimeFormat) ([]any, error) {
+	movies := make([]any, len(moviePtrs))
+	for i, moviePtr := range moviePtrs {
+		movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		movies[i] = movie
+	}
+	return movies, nil
+}
+
+// presentMovieVersion is presentMovie for a movie as it was at one of its versions
+func presentMovieVersion(version data.MovieVersion, runtimeFormat data.RuntimeFormat) any {
+	if runtimeFormat == data.RuntimeFormatHuman {
+		return version
+	}
+	return struct {
+		data.MovieVersion
+		Runtime any `json:"runtime"`
+	}{version, version.Runtime.Format(runtimeFormat)}
+}
+
+// presentMovieVersions is presentMovieVersion for a page of a movie's history
+func presentMovieVersions(versionPtrs []*data.MovieVersion, runtimeFormat data.RuntimeFormat) []any {
+	versions := make([]any, len(versionPtrs))
+	for i, versionPtr := range versionPtrs {
+		versions[i] = presentMovieVersion(*versionPtr, runtimeFormat)
+	}
+	return versions
+}
+
+// presentWatchlistEntry is presentMovie for the movie on a watchlist entry
+func presentWatchlistEntry(entry data.WatchlistEntry, runtimeFormat data.RuntimeFormat) (any, error) {
+	movie, err := presentMovie(entry.Movie, nil, runtimeFormat)
+	if err != nil {
+		return nil, err
+	}
+	// as in presentMovie, the movie of the outer struct hides that of the entry
+	return struct {
+		data.WatchlistEntry
+		Movie any `json:"movie"`
+	}{entry, movie}, nil
+}
+
+// readRuntimeFormat reads ?runtime_format, which is human ("90 mins") unless the client asks for minutes or iso8601
+func (appPtr *application) readRuntimeFormat(qs url.Values, queryValidatorPtr *validator.Validator) data.RuntimeFormat {
+	runtimeFormat := appPtr.readString(qs, "runtime_format", string(data.RuntimeFormatHuman))
+	queryValidatorPtr.Check(
+		validator.PermittedValue(runtimeFormat, data.RuntimeFormats...),
+		"runtime_format",
+		fmt.Sprintf("must be one of %s", strings.Join(data.RuntimeFormats, ", ")),
+	)
+	return data.RuntimeFormat(runtimeFormat)
+}
+
 /*********************************************************************************************************************/
 //WRITE JSON HELPER
 func (appPtr *application) writeJSON(w http.ResponseWriter, status int, wrappedData envelope, headers http.Header) error {
@@ -206,6 +449,36 @@ func (appPtr *application) readInt(qs url.Values, key string, defaultValue int,
 	return intVal
 }
 
+// The readBool() helper reads a "true" or "false" query string value. It returns nil if
+// the key is missing so that callers can tell "not provided" apart from false.
+func (appPtr *application) readBool(qs url.Values, key string, queryValidatorPtr *validator.Validator) *bool {
+	value := qs.Get(key)
+
+	if value == "" {
+		return nil
+	}
+
+	boolVal, err := strconv.ParseBool(value)
+	queryValidatorPtr.Check(
+		err == nil,
+		key,
+		"must be true or false",
+	)
+	if err != nil {
+		return nil
+	}
+	return &boolVal
+}
+
+// The readRange() helper reads the <key>_min and <key>_max query string values as
+// integers into a data.Range. A bound the client didn't provide is left as 0 (unbounded).
+func (appPtr *application) readRange(qs url.Values, key string, queryValidatorPtr *validator.Validator) data.Range {
+	return data.Range{
+		Min: appPtr.readInt(qs, key+"_min", 0, queryValidatorPtr),
+		Max: appPtr.readInt(qs, key+"_max", 0, queryValidatorPtr),
+	}
+}
+
 // The readCSV() helper reads a string value from the query string and then splits it
 // into a slice on the comma character. If no matching key could be found, it returns
 // the provided default value.
@@ -242,7 +515,7 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return sliceVal
 }
 
-func (appPtr *application) PseudoreadCSV(key string, queryValidatorPtr *validator.Validator, r *http.Request) []string {
+func (appPtr *application) PseudoreadCSV(key string, allowedGenres []string, queryValidatorPtr *validator.Validator, r *http.Request) []string {
 	queryParams := r.URL.Query()
 	value := queryParams.Get(key) //value is probably somn like "crime,action"
 
@@ -251,7 +524,7 @@ func (appPtr *application) PseudoreadCSV(key string, queryValidatorPtr *validato
 	//have to check if all the elements in the array is a valid genre
 	for _, csvElem := range csvSlice {
 		queryValidatorPtr.Check(
-			validator.PermittedValue(csvElem, data.AllowedGenres...),
+			validator.PermittedValue(csvElem, allowedGenres...),
 			key,
 			fmt.Sprintf("csvElem is not a valid %s value", key),
 		)
@@ -260,6 +533,21 @@ func (appPtr *application) PseudoreadCSV(key string, queryValidatorPtr *validato
 	return csvSlice
 }
 
+/*********************************************************************************************************************/
+// EXTEND THE DEADLINES OF A LONG RUNNING REQUEST
+// The server gives every request 5 seconds to send its body and 10 seconds to get a response, which isn't enough to
+// stream thousands of movies in or out. extendDeadlines moves both deadlines for this one request to timeout from
+// now. It works through our metricsResponseWriter because that has an Unwrap method.
+func (appPtr *application) extendDeadlines(w http.ResponseWriter, timeout time.Duration) error {
+	responseController := http.NewResponseController(w)
+	deadline := time.Now().Add(timeout)
+
+	if err := responseController.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	return responseController.SetWriteDeadline(deadline)
+}
+
 // We would ideally call this function using the "go" keyword
 // so that it runs in a separate goroutine, someFunc will
 // run and any panics will be handled by the defer statement.
@@ -281,6 +569,65 @@ func (appPtr *application) background(fn func()) {
 	}()
 }
 
+// purgeTrash runs until doneCh is closed on shutdown. Every trash-purge-interval it permanently
+// deletes the movies that have been in the trash for longer than trash-retention, and their images. A purge
+// already under way when doneCh is closed is finished first, see serve. A retention of 0 keeps deleted
+// movies forever.
+func (appPtr *application) purgeTrash(doneCh <-chan struct{}) {
+	if appPtr.config.trash.retention <= 0 || appPtr.config.trash.purgeInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(appPtr.config.trash.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+		}
+
+		purged, blobKeys, err := appPtr.dbModel.MovieModel.PurgeDeleted(appPtr.config.trash.retention)
+		if err != nil {
+			appPtr.logger.Error("purge trash", "error", err)
+			continue
+		}
+		// the movies' images go with them, a blob we fail to delete is only wasted space
+		for _, blobKey := range blobKeys {
+			if err := appPtr.blobStore.Delete(blobKey); err != nil {
+				appPtr.logger.Error("purge trash", "blob", blobKey, "error", err)
+			}
+		}
+		if purged > 0 {
+			appPtr.logger.Info("purged trash", "movies", purged, "blobs", len(blobKeys))
+		}
+	}
+}
+
+// purgeIdempotencyKeys deletes the Idempotency-Keys past their TTL every hour until doneCh is closed on shutdown,
+// see the idempotent middleware
+func (appPtr *application) purgeIdempotencyKeys(doneCh <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+		}
+
+		purged, err := appPtr.dbModel.IdempotencyModel.DeleteExpired()
+		if err != nil {
+			appPtr.logger.Error("purge idempotency keys", "error", err)
+			continue
+		}
+		if purged > 0 {
+			appPtr.logger.Info("purged idempotency keys", "keys", purged)
+		}
+	}
+}
+
 /*********************************************************************************************************************/
 /*
 QUESTION:
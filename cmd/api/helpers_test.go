@@ -0,0 +1,75 @@
+package main
+
+import (
+	"greenlight-movie-api/internal/data"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{name: "same strong tag", header: `"3"`, etag: `"3"`, want: true},
+		{name: "other tag", header: `"4"`, etag: `"3"`, want: false},
+		{name: "one of a list", header: `"2", "3"`, etag: `"3"`, want: true},
+		{name: "list without spaces", header: `"2","3"`, etag: `"3"`, want: true},
+		{name: "none of a list", header: `"1", "2"`, etag: `"3"`, want: false},
+		{name: "any", header: `*`, etag: `"3"`, want: true},
+		{name: "unquoted", header: `3`, etag: `"3"`, want: false},
+		{name: "empty header", header: ``, etag: `"3"`, want: false},
+		{name: "weak tag, strong comparison", header: `W/"3"`, etag: `"3"`, want: false},
+		{name: "weak tag, weak comparison", header: `W/"3"`, etag: `"3"`, weak: true, want: true},
+		{name: "strong tag, weak comparison", header: `"3"`, etag: `"3"`, weak: true, want: true},
+		{name: "weak tag among strong ones", header: `W/"3", "4"`, etag: `"3"`, want: false},
+		{name: "representation tag", header: `"3-9f86d081884c7d65"`, etag: `"3-9f86d081884c7d65"`, weak: true, want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := etagMatches(test.header, test.etag, test.weak); got != test.want {
+				t.Errorf("etagMatches(%q, %q, %t) = %t; want %t", test.header, test.etag, test.weak, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifMatch     string
+		wantFails   bool
+		wantVersion int32
+	}{
+		{name: "no If-Match", ifMatch: "", wantFails: false, wantVersion: 0},
+		{name: "current version", ifMatch: `"3"`, wantFails: false, wantVersion: 3},
+		{name: "older version", ifMatch: `"2"`, wantFails: true, wantVersion: 3},
+		{name: "representation tag of the current version", ifMatch: `"3-9f86d081884c7d65"`, wantFails: false, wantVersion: 3},
+		{name: "representation tag of an older version", ifMatch: `"2-9f86d081884c7d65"`, wantFails: true, wantVersion: 3},
+		{name: "one of a list", ifMatch: `"1", "3-9f86d081884c7d65"`, wantFails: false, wantVersion: 3},
+		{name: "weak tag", ifMatch: `W/"3"`, wantFails: true, wantVersion: 3},
+		{name: "unquoted", ifMatch: `3-9f86d081884c7d65`, wantFails: true, wantVersion: 3},
+		{name: "any", ifMatch: `*`, wantFails: false, wantVersion: 0},
+	}
+
+	appPtr := &application{}
+	moviePtr := &data.Movie{ID: 1, Version: 3}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/v1/movies/1", nil)
+			if test.ifMatch != "" {
+				r.Header.Set("If-Match", test.ifMatch)
+			}
+			if got := appPtr.ifMatchFails(r, moviePtr); got != test.wantFails {
+				t.Errorf("ifMatchFails = %t; want %t", got, test.wantFails)
+			}
+			if got := ifMatchVersion(r, moviePtr); got != test.wantVersion {
+				t.Errorf("ifMatchVersion = %d; want %d", got, test.wantVersion)
+			}
+		})
+	}
+}
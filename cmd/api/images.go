@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder with image.Decode
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder with image.Decode
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// the largest image we accept, the multipart form around it gets a little extra room
+	maxImageBytes = 10 << 20
+	// the most pixels an image can have on either side, this keeps a small file that decodes
+	// into an enormous image (a decompression bomb) from eating all our memory
+	maxImageDimension = 8000
+)
+
+// The image types we accept, as sniffed from the upload itself (never from what the client claims it is), and the
+// extension we store each one with
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Every thumbnail of a kind of image is exactly the same size, so clients can lay them out before they've loaded
+var thumbnailSizes = map[string]image.Point{
+	data.ImagePoster: {X: 200, Y: 300},
+	data.ImageStill:  {X: 320, Y: 180},
+}
+
+/*********************************************************************************************************************/
+//POST /v1/movies/:id/images
+//To upload a poster or a still for a movie as multipart/form-data, with the file in the "image" field and "poster"
+//or "still" in the "kind" field. We store the image as it was sent along with a thumbnail. Read notes(1)
+func (appPtr *application) uploadMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	// a large upload on a slow connection needs longer than the server's usual 5 seconds
+	err := appPtr.extendDeadlines(w, time.Minute)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes+1<<20)
+	err = r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			appPtr.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("image must not be larger than %d bytes", maxImageBytes))
+		default:
+			appPtr.badRequestResponse(w, r, fmt.Errorf("body must be multipart/form-data: %w", err))
+		}
+		return
+	}
+	// the parts of the form that didn't fit in memory are kept in temporary files
+	defer r.MultipartForm.RemoveAll()
+
+	imageValidatorPtr := validator.New()
+	kind := r.FormValue("kind")
+	imageValidatorPtr.Check(validator.PermittedValue(kind, data.ImageKinds...), "kind", fmt.Sprintf("must be one of %v", data.ImageKinds))
+
+	filePtr, fileHeaderPtr, err := r.FormFile("image")
+	imageValidatorPtr.Check(err == nil, "image", "must be provided as a file")
+	if err == nil {
+		defer filePtr.Close()
+		imageValidatorPtr.Check(fileHeaderPtr.Size <= maxImageBytes, "image", fmt.Sprintf("must not be larger than %d bytes", maxImageBytes))
+	}
+	if !imageValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, imageValidatorPtr.Errors)
+		return
+	}
+
+	// Sniff what the file really is from its first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(filePtr, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	extension, allowed := imageExtensions[contentType]
+	if !allowed {
+		appPtr.errorResponse(w, r, http.StatusUnsupportedMediaType, "image must be a JPEG, PNG or GIF")
+		return
+	}
+
+	// Check the dimensions before decoding the whole image
+	if _, err := filePtr.Seek(0, io.SeekStart); err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	config, _, err := image.DecodeConfig(filePtr)
+	imageValidatorPtr.Check(err == nil, "image", "could not be read as an image")
+	if err == nil {
+		imageValidatorPtr.Check(
+			config.Width > 0 && config.Height > 0 && config.Width <= maxImageDimension && config.Height <= maxImageDimension,
+			"image",
+			fmt.Sprintf("must be at most %d pixels wide and high", maxImageDimension),
+		)
+	}
+	if !imageValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, imageValidatorPtr.Errors)
+		return
+	}
+
+	if _, err := filePtr.Seek(0, io.SeekStart); err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	decodedImage, _, err := image.Decode(filePtr)
+	if err != nil {
+		imageValidatorPtr.AddError("image", "could not be read as an image")
+		appPtr.failedValidationResponse(w, r, imageValidatorPtr.Errors)
+		return
+	}
+
+	thumbnailBuffer := new(bytes.Buffer)
+	err = jpeg.Encode(thumbnailBuffer, thumbnail(decodedImage, thumbnailSizes[kind]), &jpeg.Options{Quality: 85})
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	name, err := randomBlobName()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	movieImage := data.MovieImage{
+		MovieID:      moviePtr.ID,
+		Kind:         kind,
+		ContentType:  contentType,
+		Width:        config.Width,
+		Height:       config.Height,
+		BlobKey:      fmt.Sprintf("movies/%d/%s%s", moviePtr.ID, name, extension),
+		ThumbnailKey: fmt.Sprintf("movies/%d/%s_thumb.jpg", moviePtr.ID, name),
+	}
+
+	// Store the original exactly as it was uploaded, then the thumbnail
+	if _, err := filePtr.Seek(0, io.SeekStart); err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	err = appPtr.blobStore.Put(movieImage.BlobKey, filePtr)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	err = appPtr.blobStore.Put(movieImage.ThumbnailKey, thumbnailBuffer)
+	if err == nil {
+		err = appPtr.dbModel.MovieImageModel.InsertImage(&movieImage)
+	}
+	if err != nil {
+		// don't leave blobs behind that no movie refers to
+		appPtr.deleteImageBlobs(r, &movieImage)
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	appPtr.setImageURLs(&movieImage)
+
+	headers := http.Header{}
+	headers.Set("Location", movieImage.URL)
+
+	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"image": movieImage}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//GET /v1/movies/:id/images
+//To list the posters and stills of a movie
+func (appPtr *application) listMovieImagesHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	imagePtrs, err := appPtr.dbModel.MovieImageModel.GetAllForMovie(moviePtr.ID)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	for _, imagePtr := range imagePtrs {
+		appPtr.setImageURLs(imagePtr)
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"images": imagePtrs}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies/:id/images/:image_id
+//To remove an image from a movie, along with its blobs
+func (appPtr *application) deleteMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	imageID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("image_id"), 10, 64)
+	if err != nil || imageID < 1 {
+		appPtr.badRequestResponse(w, r, errors.New("read image_id: invalid image_id parameter"))
+		return
+	}
+
+	imagePtr, err := appPtr.dbModel.MovieImageModel.DeleteImage(movieID, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	appPtr.deleteImageBlobs(r, imagePtr)
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//GET /v1/images/*filepath
+//Serves the blobs of the local blob store. Images are artwork for everyone to see, so unlike the rest of the API
+//no permission is needed to fetch them. Directories are never listed, and neither is a file whose name (or a
+//directory on its path) starts with a dot: those are the temporary files a blob is written to before it is complete,
+//see LocalStore.Put, and no blob key has one.
+func (appPtr *application) serveImagesHandler(root string) http.Handler {
+	fileServer := http.StripPrefix("/v1/images", http.FileServer(http.Dir(root)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			appPtr.notFoundHandler(w, r)
+			return
+		}
+		for _, segment := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(segment, ".") {
+				appPtr.notFoundHandler(w, r)
+				return
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// setImageURLs fills in where clients can fetch an image and its thumbnail from
+func (appPtr *application) setImageURLs(imagePtr *data.MovieImage) {
+	imagePtr.URL = appPtr.blobStore.URL(imagePtr.BlobKey)
+	imagePtr.ThumbnailURL = appPtr.blobStore.URL(imagePtr.ThumbnailKey)
+}
+
+// deleteImageBlobs removes an image and its thumbnail from the blob store. A blob we fail to delete is only wasted
+// space, so the error is logged rather than sent to the client
+func (appPtr *application) deleteImageBlobs(r *http.Request, imagePtr *data.MovieImage) {
+	for _, blobKey := range []string{imagePtr.BlobKey, imagePtr.ThumbnailKey} {
+		if err := appPtr.blobStore.Delete(blobKey); err != nil {
+			appPtr.logError(r, fmt.Errorf("delete blob %s: %w", blobKey, err))
+		}
+	}
+}
+
+// randomBlobName returns a random name for a blob, so uploads never overwrite each other and their URLs can't be
+// guessed from the movie and image ids
+func randomBlobName() (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+/*********************************************************************************************************************/
+/*
+THUMBNAILS
+thumbnail scales an image to exactly size. The image is first cropped around its center to the thumbnail's aspect
+ratio so that it is never squashed, then every thumbnail pixel is the average of (up to 4x4 of) the image pixels it
+covers. Transparent areas are laid over white, since JPEG has no transparency.
+*/
+func thumbnail(img image.Image, size image.Point) *image.RGBA64 {
+	crop := img.Bounds()
+	if crop.Dx()*size.Y > crop.Dy()*size.X {
+		// too wide, trim the left and right
+		width := crop.Dy() * size.X / size.Y
+		crop.Min.X += (crop.Dx() - width) / 2
+		crop.Max.X = crop.Min.X + width
+	} else {
+		// too tall, trim the top and bottom
+		height := crop.Dx() * size.Y / size.X
+		crop.Min.Y += (crop.Dy() - height) / 2
+		crop.Max.Y = crop.Min.Y + height
+	}
+
+	thumbnailPtr := image.NewRGBA64(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		y0 := crop.Min.Y + y*crop.Dy()/size.Y
+		y1 := max(crop.Min.Y+(y+1)*crop.Dy()/size.Y, y0+1)
+		stepY := max((y1-y0)/4, 1)
+
+		for x := 0; x < size.X; x++ {
+			x0 := crop.Min.X + x*crop.Dx()/size.X
+			x1 := max(crop.Min.X+(x+1)*crop.Dx()/size.X, x0+1)
+			stepX := max((x1-x0)/4, 1)
+
+			var red, green, blue, alpha, samples uint64
+			for sourceY := y0; sourceY < y1; sourceY += stepY {
+				for sourceX := x0; sourceX < x1; sourceX += stepX {
+					r, g, b, a := img.At(sourceX, sourceY).RGBA()
+					red, green, blue, alpha = red+uint64(r), green+uint64(g), blue+uint64(b), alpha+uint64(a)
+					samples++
+				}
+			}
+			// the colours are premultiplied by alpha, so adding what is left of the alpha
+			// to each of them is the same as drawing the pixel over white
+			white := 0xffff - alpha/samples
+			thumbnailPtr.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(red/samples + white),
+				G: uint16(green/samples + white),
+				B: uint16(blue/samples + white),
+				A: 0xffff,
+			})
+		}
+	}
+	return thumbnailPtr
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES
+1 - UPLOADS
+The "image" part of the form is checked three times before we keep it: its size (MaxBytesReader and the file header),
+its type (http.DetectContentType on its first bytes, so a script renamed to poster.jpg is refused) and its dimensions
+(image.DecodeConfig reads only the header, so we refuse a 50000x50000 image before decoding it). The original is stored
+byte for byte as it was uploaded and only the thumbnail is re-encoded. Both go through appPtr.blobStore, so where they
+end up (the local disk today) is decided in main.go and nowhere else.
+*/
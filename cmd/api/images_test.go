@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// stripes returns an image of the given bounds split into equal stripes of the colours, side by side when vertical,
+// one above the other otherwise
+func stripes(bounds image.Rectangle, vertical bool, colours ...color.Color) *image.NRGBA64 {
+	imgPtr := image.NewNRGBA64(bounds)
+	for i, colour := range colours {
+		stripe := bounds
+		if vertical {
+			stripe.Min.X = bounds.Min.X + i*bounds.Dx()/len(colours)
+			stripe.Max.X = bounds.Min.X + (i+1)*bounds.Dx()/len(colours)
+		} else {
+			stripe.Min.Y = bounds.Min.Y + i*bounds.Dy()/len(colours)
+			stripe.Max.Y = bounds.Min.Y + (i+1)*bounds.Dy()/len(colours)
+		}
+		draw.Draw(imgPtr, stripe, image.NewUniform(colour), image.Point{}, draw.Src)
+	}
+	return imgPtr
+}
+
+func TestThumbnail(t *testing.T) {
+	red := color.RGBA64{R: 0xffff, A: 0xffff}
+	green := color.RGBA64{G: 0xffff, A: 0xffff}
+	blue := color.RGBA64{B: 0xffff, A: 0xffff}
+	black := color.RGBA64{A: 0xffff}
+	white := color.RGBA64{R: 0xffff, G: 0xffff, B: 0xffff, A: 0xffff}
+
+	tests := []struct {
+		name string
+		img  image.Image
+		size image.Point
+		// the colour every pixel of the thumbnail should be
+		want color.RGBA64
+	}{
+		{name: "too wide, keeps the middle", img: stripes(image.Rect(0, 0, 300, 100), true, red, green, blue), size: image.Pt(10, 10), want: green},
+		{name: "too tall, keeps the middle", img: stripes(image.Rect(0, 0, 100, 300), false, red, green, blue), size: image.Pt(10, 10), want: green},
+		{name: "wide thumbnail of a square", img: stripes(image.Rect(0, 0, 90, 90), false, red, green, blue), size: image.Pt(30, 10), want: green},
+		{name: "bounds not at the origin", img: stripes(image.Rect(50, 20, 350, 120), true, red, green, blue), size: image.Pt(10, 10), want: green},
+		{name: "scaled up", img: stripes(image.Rect(0, 0, 1, 1), true, blue), size: image.Pt(8, 12), want: blue},
+		{name: "pixels averaged", img: stripes(image.Rect(0, 0, 2, 2), true, black, white), size: image.Pt(1, 1), want: color.RGBA64{R: 0x7fff, G: 0x7fff, B: 0x7fff, A: 0xffff}},
+		{name: "transparent over white", img: stripes(image.Rect(0, 0, 4, 4), true, color.NRGBA{}), size: image.Pt(2, 2), want: white},
+		{name: "half transparent over white", img: stripes(image.Rect(0, 0, 4, 4), true, color.NRGBA{A: 0x80}), size: image.Pt(2, 2), want: color.RGBA64{R: 0x7f7f, G: 0x7f7f, B: 0x7f7f, A: 0xffff}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thumbnailPtr := thumbnail(test.img, test.size)
+			if bounds := thumbnailPtr.Bounds(); bounds != image.Rect(0, 0, test.size.X, test.size.Y) {
+				t.Fatalf("got bounds %v; want %v", bounds, test.size)
+			}
+			for y := 0; y < test.size.Y; y++ {
+				for x := 0; x < test.size.X; x++ {
+					if got := thumbnailPtr.RGBA64At(x, y); got != test.want {
+						t.Fatalf("pixel (%d, %d) is %v; want %v", x, y, got, test.want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"greenlight-movie-api/internal/blobstore"
 	"greenlight-movie-api/internal/data"
 	"greenlight-movie-api/internal/mailer"
 	"greenlight-movie-api/internal/vcs"
@@ -70,6 +71,17 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	images struct {
+		dir     string
+		baseURL string
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
 }
 
 /*********************************************************************************************************************/
@@ -89,6 +101,8 @@ type application struct {
 	dbModel data.Models
 	mailer  mailer.Mailer
 	wg      *sync.WaitGroup //I use a pointer whereas the author does not
+	//where uploaded files such as movie posters are kept
+	blobStore blobstore.Store
 }
 
 /*********************************************************************************************************************/
@@ -214,6 +228,11 @@ func main() {
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.akindipejohn.net>", "SMTP sender")
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "jwt secret key")
 	flag.Func("cors-trusted-origins", CORS_USAGE_FLAG, verifyCorsFlag)
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "how long deleted movies are kept before they are purged (0 keeps them forever)")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "how often deleted movies past the retention are purged")
+	flag.StringVar(&cfg.images.dir, "images-dir", "./uploads", "directory movie images are stored in")
+	flag.StringVar(&cfg.images.baseURL, "images-base-url", "/v1/images", "URL movie images are served from")
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "how long the response to a request with an Idempotency-Key is replayed")
     displayVersion := flag.Bool("version", false, "Display version and exit") //Create a version boolean flag with the default value of false.
 	flag.Parse()
 
@@ -251,6 +270,14 @@ func main() {
 		cfg.smtp.sender,
 	)
 	/*********************************************************************************************************************/
+	//BLOB STORE SETUP
+	//Movie images are kept on the local disk for now, anything satisfying blobstore.Store can take its place
+	blobStore, err := blobstore.NewLocal(cfg.images.dir, cfg.images.baseURL)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	/*********************************************************************************************************************/
 	//SET UP CUSTOM METRIC INFO
 	//Publish the current API version
 	expvar.NewString("version").Set(version)
@@ -278,11 +305,12 @@ func main() {
 		moviesModel, whose dbPtr field is populated by the dbPtr we pass in
 	*/
 	appPtr := &application{
-		config:  cfg,
-		logger:  logger,
-		dbModel: data.NewModel(dbPtr),
-		mailer:  mailer,
-		wg:      &sync.WaitGroup{},
+		config:    cfg,
+		logger:    logger,
+		dbModel:   data.NewModel(dbPtr),
+		mailer:    mailer,
+		wg:        &sync.WaitGroup{},
+		blobStore: blobStore,
 	}
 	/*********************************************************************************************************************/
 	err = appPtr.serve()
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"expvar"
 	"fmt"
 	"greenlight-movie-api/internal/data"
 	"greenlight-movie-api/internal/validator"
+	"io"
 	"net/http"
 	"slices"
 	"strconv"
@@ -390,6 +393,8 @@ func (appPtr *application) enableCORS(next http.Handler) http.Handler {
 
 		if slices.Contains(appPtr.config.cors.trustedOrigins, origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
+			//let scripts read the ETag, they need it for If-Match and If-None-Match
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed")
 		}
 		//allow request proceed as nrmal if no match found, thus
 		//the request will default to only same-site origin allowed
@@ -401,7 +406,7 @@ func (appPtr *application) enableCORS(next http.Handler) http.Handler {
 				if r.Header.Get("Access-Control-Request-Method") != "" {
 					// This is a pre-flight request
 					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key")
 
 					w.WriteHeader(http.StatusOK)
 					return
@@ -456,6 +461,157 @@ func (appPtr *application) metrics(next http.Handler) http.Handler {
 	})
 }
 
+/*********************************************************************************************************************/
+/*
+The IDEMPOTENT middleware lets a client safely retry a POST that may or may not have gone through (e.g. the connection
+dropped before the response arrived) by sending the same Idempotency-Key header with every attempt. The first request
+with a key is handled as usual and its response is stored, every retry gets that stored response back instead of
+creating another movie or user. A handler opts in by being wrapped in it, inside the authentication middleware so that
+keys are kept per user. Read notes(7)
+*/
+func (appPtr *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			appPtr.badRequestResponse(w, r, errors.New("the Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+		// every client that isn't signed in shares the anonymous user's scope, only a random key keeps them apart
+		userPtr := appPtr.contextGetUser(r)
+		if userPtr.IsAnonymous() && !validator.Matches(key, validator.UUIDRX) {
+			appPtr.badRequestResponse(w, r, errors.New("the Idempotency-Key header must be a UUID when you aren't authenticated"))
+			return
+		}
+
+		// The fingerprint needs the whole body, so we read it here and hand the handler a copy
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			appPtr.badRequestResponse(w, r, fmt.Errorf("read body: %w", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s\n", r.Method, r.URL.RequestURI())
+		fingerprint.Write(body)
+
+		record := data.IdempotencyRecord{
+			Scope:       fmt.Sprintf("%s %s user:%d", r.Method, r.URL.Path, userPtr.ID),
+			Key:         key,
+			Fingerprint: fingerprint.Sum(nil),
+		}
+
+		reserved, err := appPtr.dbModel.IdempotencyModel.Reserve(&record, appPtr.config.idempotency.ttl)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+		if !reserved {
+			appPtr.replayIdempotentResponse(w, r, &record)
+			return
+		}
+
+		// Until we have a response to store, a failure on our side (a panic included) frees the key so that
+		// the client's retry is handled afresh rather than being told it is still in progress
+		completed := false
+		defer func() {
+			if !completed {
+				if err := appPtr.dbModel.IdempotencyModel.Release(record.Scope, record.Key); err != nil {
+					appPtr.logError(r, err)
+				}
+			}
+		}()
+
+		recorderPtr := newRecordingResponseWriter(w)
+		next.ServeHTTP(recorderPtr, r)
+
+		if recorderPtr.statusCode >= http.StatusInternalServerError {
+			return
+		}
+		record.Status = recorderPtr.statusCode
+		record.Headers = http.Header{}
+		for _, header := range idempotentHeaders {
+			if values := recorderPtr.Header().Values(header); len(values) > 0 {
+				record.Headers[header] = values
+			}
+		}
+		record.Body = recorderPtr.body.Bytes()
+
+		if err := appPtr.dbModel.IdempotencyModel.Complete(&record); err != nil {
+			// the client already has its response, all we lose is the replay
+			appPtr.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// replayIdempotentResponse answers a request whose Idempotency-Key has been seen before: with the stored response if
+// it is a retry of the same request, or with an error if the key was used for a different request or the first
+// request with it hasn't finished yet
+func (appPtr *application) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, requestPtr *data.IdempotencyRecord) {
+	recordPtr, err := appPtr.dbModel.IdempotencyModel.Get(requestPtr.Scope, requestPtr.Key)
+	if err != nil {
+		switch {
+		// the key expired between our reserving and reading it, let the client try again
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.errorResponse(w, r, http.StatusConflict, "the Idempotency-Key has just expired, please retry the request")
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case !bytes.Equal(recordPtr.Fingerprint, requestPtr.Fingerprint):
+		appPtr.errorResponse(w, r, http.StatusUnprocessableEntity, "the Idempotency-Key has already been used for a request with a different body")
+	case recordPtr.Status == 0:
+		appPtr.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being processed, please retry later")
+	default:
+		for header, values := range recordPtr.Headers {
+			w.Header()[header] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(recordPtr.Status)
+		w.Write(recordPtr.Body)
+	}
+}
+
+// the largest body a request with an Idempotency-Key can have, the same limit readJSON puts on every JSON body
+const maxIdempotentBodyBytes = 1_048_576
+
+// The response headers we store along with the body, the ones a client needs to make sense of it
+var idempotentHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// A recordingResponseWriter passes a response through to the client while keeping a copy of its status and body
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newRecordingResponseWriter(w http.ResponseWriter) *recordingResponseWriter {
+	return &recordingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+func (rw *recordingResponseWriter) WriteHeader(statusCode int) {
+	rw.statusCode = statusCode
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 /*
 1 CLOSE CONNECTION MANUALLY
 Panic would usually unwind the entire goroutine stack, call
@@ -536,4 +692,16 @@ total_requests_received - total_responses_sent
 (total_requests_received_B - total_requests_received_A) / (timestamp_B - timestamp_A)
 - The average processing time per request (between calls A and B to the GET /debug/vars endpoint):
 (total_processing_time_μs_B - total_processing_time_μs_A) / (total_requests_received_B - total_requests_received_A)
+
+7. IDEMPOTENCY KEYS
+A key is remembered per method, path and user (the scope), so two users, or one user on two endpoints, can't see each
+other's responses by picking the same key. Clients that aren't authenticated (e.g. registering on POST /v1/users) all
+share the scope of the anonymous user, so they must send a UUID as their key: two of them only collide if they pick the
+same random UUID, which doesn't happen. With the key we store a SHA-256 fingerprint of the method, the URL and the body:
+a retry must be the very same request, reusing a key for a different one is a client bug we answer with 422 rather than
+guess which of the two it meant. Reserving the key is a single INSERT ... ON CONFLICT, so of two retries racing each
+other exactly one is handled and the other is told the first is still in progress (409). Responses are stored whatever
+their status, a retry of an invalid movie gets the same 422 back, except for 5xx errors: those free the key, since the
+client should be able to retry a request that failed on our side. Keys are replayed for -idempotency-ttl and the expired
+ones are deleted by purgeIdempotencyKeys.
 */
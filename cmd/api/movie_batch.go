@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+)
+
+// the most movies a single batch request can change
+const maxBatchSize = 100
+
+/*********************************************************************************************************************/
+/*
+BATCH REPORT
+What happened to every movie of a batch, in the order the client sent them. A movie is "updated" or "deleted" (with
+the movie as it now is), "not_found" when there is no such movie (or it is in the trash), "conflict" when it isn't at
+the version the client sent any more, "invalid" (with the same errors PATCH /v1/movies/:id would have given) or
+"skipped" when nothing was wrong with it but the batch was rolled back because of another movie.
+*/
+type batchItemResult struct {
+	ID     int64             `json:"id"`
+	Status string            `json:"status"`
+	Movie  any               `json:"movie,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+/*********************************************************************************************************************/
+//PATCH /v1/movies
+//To update many movies at once, atomically. The body is {"movies": [{"id": 1, "version": 3, "changes": {...}}]} where
+//changes holds the fields to change, exactly as for PATCH /v1/movies/:id. Either every movie is updated or none is.
+//Read notes(1)
+func (appPtr *application) batchUpdateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []struct {
+			ID      int64 `json:"id"`
+			Version int32 `json:"version"`
+			Changes struct {
+				Title   *string       `json:"title"`
+				Year    *int32        `json:"year"`
+				Runtime *data.Runtime `json:"runtime"`
+				Genres  []string      `json:"genres"`
+				Status  *string       `json:"status"`
+			} `json:"changes"`
+		} `json:"movies"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(input.Movies))
+	for i, item := range input.Movies {
+		ids[i] = item.ID
+	}
+	runtimeFormat, ok := appPtr.validateBatch(w, r, ids)
+	if !ok {
+		return
+	}
+
+	currentMovies, err := appPtr.dbModel.MovieModel.GetMovies(ids)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	activeGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]*batchItemResult, len(input.Movies))
+	moviePtrs := []*data.Movie{}
+	failed := false
+	for i, item := range input.Movies {
+		results[i] = &batchItemResult{ID: item.ID, Status: "skipped"}
+
+		moviePtr, exists := currentMovies[item.ID]
+		switch {
+		case !exists:
+			results[i].Status = "not_found"
+			failed = true
+			continue
+		case moviePtr.Version != item.Version:
+			results[i].Status = "conflict"
+			failed = true
+			continue
+		}
+
+		// the genres it has now, which it can keep even if they have been retired since
+		currentGenres := moviePtr.Genres
+
+		if item.Changes.Title != nil {
+			moviePtr.Title = *item.Changes.Title
+		}
+		if item.Changes.Year != nil {
+			moviePtr.Year = *item.Changes.Year
+		}
+		if item.Changes.Runtime != nil {
+			moviePtr.Runtime = *item.Changes.Runtime
+		}
+		if item.Changes.Genres != nil {
+			moviePtr.Genres = item.Changes.Genres
+		}
+		if item.Changes.Status != nil {
+			moviePtr.Status = *item.Changes.Status
+		}
+
+		movieValidatorPtr := validator.New()
+		data.ValidateMovie(movieValidatorPtr, moviePtr, data.GenresForEdit(activeGenres, currentGenres))
+		if !movieValidatorPtr.Valid() {
+			results[i].Status = "invalid"
+			results[i].Errors = movieValidatorPtr.Errors
+			failed = true
+			continue
+		}
+		moviePtrs = append(moviePtrs, moviePtr)
+	}
+
+	if !failed {
+		var conflicts []int64
+		conflicts, err = appPtr.dbModel.MovieModel.UpdateMovies(moviePtrs, appPtr.contextGetUser(r).ID)
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+		err = markBatchResults(results, currentMovies, conflicts, "updated", runtimeFormat)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	appPtr.writeBatchResults(w, r, results)
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies
+//To move many movies to the trash at once, atomically. The body is {"movies": [{"id": 1, "version": 3}]}, the
+//version being the one the client last read like If-Match on DELETE /v1/movies/:id. Either every movie is deleted or
+//none is. Read notes(1)
+func (appPtr *application) batchDeleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []struct {
+			ID      int64 `json:"id"`
+			Version int32 `json:"version"`
+		} `json:"movies"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(input.Movies))
+	for i, item := range input.Movies {
+		ids[i] = item.ID
+	}
+	runtimeFormat, ok := appPtr.validateBatch(w, r, ids)
+	if !ok {
+		return
+	}
+
+	currentMovies, err := appPtr.dbModel.MovieModel.GetMovies(ids)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]*batchItemResult, len(input.Movies))
+	moviePtrs := []*data.Movie{}
+	failed := false
+	for i, item := range input.Movies {
+		results[i] = &batchItemResult{ID: item.ID, Status: "skipped"}
+
+		moviePtr, exists := currentMovies[item.ID]
+		switch {
+		case !exists:
+			results[i].Status = "not_found"
+			failed = true
+		case moviePtr.Version != item.Version:
+			results[i].Status = "conflict"
+			failed = true
+		default:
+			moviePtrs = append(moviePtrs, moviePtr)
+		}
+	}
+
+	if !failed {
+		var conflicts []int64
+		conflicts, err = appPtr.dbModel.MovieModel.DeleteMovies(moviePtrs, appPtr.contextGetUser(r).ID)
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+		err = markBatchResults(results, currentMovies, conflicts, "deleted", runtimeFormat)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	appPtr.writeBatchResults(w, r, results)
+}
+
+/*********************************************************************************************************************/
+// BATCH HELPERS
+
+// validateBatch checks the ids of a batch before we look any movie up: there must be between 1 and maxBatchSize of
+// them, each one valid and none twice. It also reads the ?runtime_format the movies are sent back in. It returns
+// false if it has already sent the client a response.
+func (appPtr *application) validateBatch(w http.ResponseWriter, r *http.Request, ids []int64) (data.RuntimeFormat, bool) {
+	batchValidatorPtr := validator.New()
+	batchValidatorPtr.Check(
+		len(ids) > 0 && len(ids) <= maxBatchSize,
+		"movies",
+		fmt.Sprintf("must contain between 1 and %d movies", maxBatchSize),
+	)
+	for _, id := range ids {
+		batchValidatorPtr.Check(id > 0, "movies", "every movie must have a positive id")
+	}
+	batchValidatorPtr.Check(validator.Unique(ids), "movies", "must not contain the same movie twice")
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), batchValidatorPtr)
+
+	if !batchValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, batchValidatorPtr.Errors)
+		return runtimeFormat, false
+	}
+	return runtimeFormat, true
+}
+
+// markBatchResults records the outcome of the transaction: the movies that changed under it are conflicts, and
+// if there were none every movie got the status done and is sent back with its runtime in runtimeFormat
+func markBatchResults(results []*batchItemResult, movies map[int64]*data.Movie, conflicts []int64, done string, runtimeFormat data.RuntimeFormat) error {
+	for _, result := range results {
+		switch {
+		case validator.PermittedValue(result.ID, conflicts...):
+			result.Status = "conflict"
+		case len(conflicts) == 0:
+			movie, err := presentMovie(*movies[result.ID], nil, runtimeFormat)
+			if err != nil {
+				return err
+			}
+			result.Status = done
+			result.Movie = movie
+		}
+	}
+	return nil
+}
+
+// writeBatchResults sends the report of a batch. The status is 200 when every movie went through, otherwise it is
+// that of the worst problem: 422 if a movie was invalid, 404 if one doesn't exist, 409 if one had changed
+func (appPtr *application) writeBatchResults(w http.ResponseWriter, r *http.Request, results []*batchItemResult) {
+	status := http.StatusOK
+	for _, result := range results {
+		switch {
+		case result.Status == "invalid":
+			status = http.StatusUnprocessableEntity
+		case result.Status == "not_found" && status != http.StatusUnprocessableEntity:
+			status = http.StatusNotFound
+		case result.Status == "conflict" && status == http.StatusOK:
+			status = http.StatusConflict
+		}
+	}
+
+	err := appPtr.writeJSON(w, status, envelope{"results": results}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES
+1 - ALL OR NOTHING
+A batch is checked in two steps. We first read every movie and check its version and (for updates) its changes the
+same way the single movie handlers do, so a batch that can't possibly go through is reported without touching the
+database. Only then do we run the updates or deletes, all in one transaction (MovieModel.UpdateMovies/DeleteMovies)
+where each statement checks the version again, since another request may have changed a movie since we read it. Any
+problem in either step means nothing is changed, and the report says which movies were the problem and that the rest
+were skipped, so the client can fix those and send the whole batch again.
+*/
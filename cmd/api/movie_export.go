@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how long an export may take to send the catalogue
+const exportTimeout = 5 * time.Minute
+
+/*********************************************************************************************************************/
+//GET /v1/movies/export?format=ndjson|csv
+//To download every movie matching the same title, genres, people, range and sort parameters GET /v1/movies takes,
+//without paging. The movies are written to the client as they are read from the database rather than marshalled
+//into one big JSON document by writeJSON. Read notes(1)
+func (appPtr *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	allowedGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movieQuery, filters := appPtr.readMovieQuery(queryString, allowedGenres, queryValidatorPtr)
+	// there are no pages in an export, these only keep ValidateFilters happy
+	filters.Page, filters.PageSize = 1, 1
+	data.ValidateFilters(queryValidatorPtr, filters)
+
+	format := appPtr.readString(queryString, "format", "ndjson")
+	queryValidatorPtr.Check(validator.PermittedValue(format, "ndjson", "csv"), "format", "must be ndjson or csv")
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime is written in NDJSON, a CSV always has it in minutes
+	// so that it can be imported again
+	runtimeFormat := appPtr.readRuntimeFormat(queryString, queryValidatorPtr)
+
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.extendDeadlines(w, exportTimeout)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var exporter movieExporter
+	switch format {
+	case "csv":
+		exporter = newCSVMovieExporter(w)
+		w.Header().Set("Content-Type", "text/csv")
+	default:
+		exporter = newNDJSONMovieExporter(w, runtimeFormat)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, format))
+
+	exported := 0
+	err = appPtr.dbModel.MovieModel.ExportMovies(movieQuery, filters, func(moviePtr *data.Movie) error {
+		exported++
+		return exporter.write(moviePtr)
+	})
+	if err == nil {
+		err = exporter.flush()
+	}
+	if err != nil {
+		if exported == 0 {
+			// nothing has been sent yet, so we can still answer with an ordinary error
+			w.Header().Del("Content-Disposition")
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+		// the 200 may already be on its way, the best we can do is cut the connection so the client
+		// sees an incomplete response instead of a complete looking export that is missing movies
+		appPtr.logError(r, fmt.Errorf("export cut short after %d movies: %w", exported, err))
+		if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+/*********************************************************************************************************************/
+/*
+EXPORTERS
+A movieExporter writes movies to the response in one of the export formats. Writes are buffered, flush sends whatever
+is still in the buffer once the last movie has been written.
+*/
+type movieExporter interface {
+	write(moviePtr *data.Movie) error
+	flush() error
+}
+
+/*
+NDJSON
+One movie per line, in the same JSON the rest of the API uses for a movie, its runtime in the format the client asked
+for.
+*/
+type ndjsonMovieExporter struct {
+	bufferPtr     *bufio.Writer
+	encoderPtr    *json.Encoder
+	runtimeFormat data.RuntimeFormat
+}
+
+func newNDJSONMovieExporter(w io.Writer, runtimeFormat data.RuntimeFormat) *ndjsonMovieExporter {
+	bufferPtr := bufio.NewWriterSize(w, 64*1024)
+	return &ndjsonMovieExporter{bufferPtr: bufferPtr, encoderPtr: json.NewEncoder(bufferPtr), runtimeFormat: runtimeFormat}
+}
+
+// Encode ends every movie with a newline, which is all NDJSON asks for
+func (ndjsonExporterPtr *ndjsonMovieExporter) write(moviePtr *data.Movie) error {
+	movie, err := presentMovie(*moviePtr, nil, ndjsonExporterPtr.runtimeFormat)
+	if err != nil {
+		return err
+	}
+	return ndjsonExporterPtr.encoderPtr.Encode(movie)
+}
+
+func (ndjsonExporterPtr *ndjsonMovieExporter) flush() error {
+	return ndjsonExporterPtr.bufferPtr.Flush()
+}
+
+/*
+CSV
+A header row followed by one row per movie. The title, year, runtime, genres and status columns are in the format the
+import reads: runtime in minutes and the genres comma separated in a single field. The import skips the other columns,
+so an export can be imported again as it is.
+*/
+type csvMovieExporter struct {
+	writerPtr     *csv.Writer
+	headerWritten bool
+}
+
+var csvExportColumns = []string{"id", "title", "year", "runtime", "genres", "status", "version", "average_rating", "review_count"}
+
+func newCSVMovieExporter(w io.Writer) *csvMovieExporter {
+	return &csvMovieExporter{writerPtr: csv.NewWriter(w)}
+}
+
+func (csvExporterPtr *csvMovieExporter) write(moviePtr *data.Movie) error {
+	if !csvExporterPtr.headerWritten {
+		if err := csvExporterPtr.writerPtr.Write(csvExportColumns); err != nil {
+			return err
+		}
+		csvExporterPtr.headerWritten = true
+	}
+	return csvExporterPtr.writerPtr.Write([]string{
+		strconv.FormatInt(moviePtr.ID, 10),
+		moviePtr.Title,
+		strconv.FormatInt(int64(moviePtr.Year), 10),
+		strconv.FormatInt(int64(moviePtr.Runtime), 10),
+		strings.Join(moviePtr.Genres, ","),
+		moviePtr.Status,
+		strconv.FormatInt(int64(moviePtr.Version), 10),
+		strconv.FormatFloat(moviePtr.AverageRating, 'f', -1, 64),
+		strconv.Itoa(*moviePtr.ReviewCount),
+	})
+}
+
+// an export with no movies still gets its header row
+func (csvExporterPtr *csvMovieExporter) flush() error {
+	if !csvExporterPtr.headerWritten {
+		if err := csvExporterPtr.writerPtr.Write(csvExportColumns); err != nil {
+			return err
+		}
+		csvExporterPtr.headerWritten = true
+	}
+	csvExporterPtr.writerPtr.Flush()
+	return csvExporterPtr.writerPtr.Error()
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES
+1 - STREAMING THE EXPORT
+writeJSON marshals the whole envelope with MarshalIndent before it writes a byte, which for the full catalogue means
+holding every movie, and then all of its JSON, in memory at once. Here MovieModel.ExportMovies hands us one row at a
+time straight off the database connection and we encode it into a 64KB buffer which goes out to the client every
+time it fills up, so memory use stays flat however many movies there are. The cost is that once the first bytes
+have gone out we can no longer change the status code, so an error halfway through closes the connection instead.
+*/
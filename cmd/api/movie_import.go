@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the most we read from an import body, a few hundred thousand movies
+	maxImportBytes = 32 << 20
+	// how long an import may take to send its body and get its report back
+	importTimeout = 5 * time.Minute
+	// how many valid rows we insert per transaction when all_or_nothing is off
+	importBatchSize = 500
+)
+
+/*********************************************************************************************************************/
+/*
+IMPORT REPORT
+What happened to every row of an import. A row is "created" (with the new movie's id), "updated" (with the id of the
+movie its external ids matched), "invalid" (with the same errors POST /v1/movies would have given for it) or "skipped"
+when it was valid but wasn't inserted, because all_or_nothing stopped the import over another row or the import was
+cut short before its batch was inserted. A row is "failed" when it was in the batch the database refused. Rows are
+numbered from 1 and don't count the CSV header or blank NDJSON lines.
+*/
+type importRow struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type importReport struct {
+	Created int          `json:"created"`
+	Updated int          `json:"updated"`
+	Invalid int          `json:"invalid"`
+	Rows    []*importRow `json:"rows"`
+}
+
+/*********************************************************************************************************************/
+//POST /v1/movies/import?all_or_nothing=true
+//To create many movies at once from a text/csv or application/x-ndjson body. The body is read one row at a time,
+//every row is validated like a movie sent to POST /v1/movies, and the valid rows are inserted in batches, one
+//transaction per batch. A row with the IMDb or TMDB id of a movie we already have updates that movie instead. With
+//all_or_nothing=true nothing is inserted unless every row is valid, and then every row is inserted in a single
+//transaction. Read notes(1) and notes(2)
+func (appPtr *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	queryValidatorPtr := validator.New()
+	allOrNothing := appPtr.readBool(r.URL.Query(), "all_or_nothing", queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	err := appPtr.extendDeadlines(w, importTimeout)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	rowReader, err := newMovieRowReader(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedImportFormat):
+			appPtr.errorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+		default:
+			appPtr.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	allowedGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	userID := appPtr.contextGetUser(r).ID
+	report := importReport{Rows: []*importRow{}}
+	// the rows each external id and each existing movie have been seen on, so that no two rows save the same movie
+	matches := importMatches{externalIDs: map[string]int{}, movies: map[int64]int{}}
+
+	// the valid rows waiting to be inserted, and their lines in the report
+	var batch []*data.Movie
+	var batchRows []*importRow
+	insertBatch := func() error {
+		// an existing movie is updated rather than created, see matchImportedMovie
+		updated := make([]bool, len(batch))
+		for i, moviePtr := range batch {
+			updated[i] = moviePtr.ID != 0
+		}
+		if err := appPtr.dbModel.MovieModel.ImportMovies(batch, userID); err != nil {
+			for _, row := range batchRows {
+				row.Status = "failed"
+			}
+			return err
+		}
+		for i, moviePtr := range batch {
+			batchRows[i].ID = moviePtr.ID
+			if updated[i] {
+				batchRows[i].Status = "updated"
+				report.Updated++
+			} else {
+				batchRows[i].Status = "created"
+				report.Created++
+			}
+		}
+		batch, batchRows = nil, nil
+		return nil
+	}
+
+	for rowNumber := 1; ; rowNumber++ {
+		input, err := rowReader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr importRowError
+		if errors.As(err, &rowErr) {
+			report.Invalid++
+			report.Rows = append(report.Rows, &importRow{Row: rowNumber, Status: "invalid", Errors: map[string]string{"row": rowErr.Error()}})
+			continue
+		}
+		if err != nil {
+			// the body itself is broken (too large, cut off...), rows from batches
+			// that have already been inserted stay inserted, read notes(1)
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				appPtr.importErrorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("import body must not be larger than %d bytes", maxBytesError.Limit), &report)
+			default:
+				appPtr.importErrorResponse(w, r, http.StatusBadRequest, err.Error(), &report)
+			}
+			return
+		}
+
+		movie := &data.Movie{
+			Title:       input.Title,
+			Year:        input.Year,
+			Runtime:     input.Runtime,
+			Genres:      input.Genres,
+			Status:      input.Status,
+			ExternalIDs: input.ExternalIDs,
+		}
+		movieValidatorPtr := validator.New()
+		data.ValidateExternalIDs(movieValidatorPtr, movie.ExternalIDs)
+		var currentGenres []string
+		if movieValidatorPtr.Valid() {
+			currentGenres, err = appPtr.matchImportedMovie(movie, rowNumber, matches, movieValidatorPtr)
+			if err != nil {
+				appPtr.importBatchErrorResponse(w, r, err, &report)
+				return
+			}
+		}
+		// the movie is checked once we know its status, a row without one keeps the status of the movie it
+		// matched and a new movie is taken to be released, as in POST /v1/movies. A row updating a movie can keep
+		// the retired genres the movie already has, as in PUT /v1/movies/:id
+		if movie.Status == "" {
+			movie.Status = data.MovieStatusReleased
+		}
+		data.ValidateMovie(movieValidatorPtr, movie, data.GenresForEdit(allowedGenres, currentGenres))
+		if !movieValidatorPtr.Valid() {
+			report.Invalid++
+			report.Rows = append(report.Rows, &importRow{Row: rowNumber, Status: "invalid", Errors: movieValidatorPtr.Errors})
+			continue
+		}
+
+		row := &importRow{Row: rowNumber, Status: "skipped"}
+		report.Rows = append(report.Rows, row)
+		batch, batchRows = append(batch, movie), append(batchRows, row)
+
+		if (allOrNothing == nil || !*allOrNothing) && len(batch) == importBatchSize {
+			if err := insertBatch(); err != nil {
+				appPtr.importBatchErrorResponse(w, r, err, &report)
+				return
+			}
+		}
+	}
+
+	if allOrNothing != nil && *allOrNothing && report.Invalid > 0 {
+		err = appPtr.writeJSON(w, http.StatusUnprocessableEntity, envelope{"import": report}, nil)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(batch) > 0 {
+		if err := insertBatch(); err != nil {
+			appPtr.importBatchErrorResponse(w, r, err, &report)
+			return
+		}
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+// MATCHING EXTERNAL IDS
+
+// importMatches records the rows of an import each external id (keyed "source:id") and each existing movie were
+// seen on
+type importMatches struct {
+	externalIDs map[string]int
+	movies      map[int64]int
+}
+
+// matchImportedMovie looks for the movie we already have with the external ids of an imported row. When there is one
+// the row is an update of it: the movie gets its ID and current version (and status, if the row has none), and the
+// genres the movie has now are returned. Rows that can't be matched to a single movie, or that repeat an external id
+// or a movie of an earlier row, are recorded as invalid in movieValidatorPtr. Read notes(2)
+func (appPtr *application) matchImportedMovie(moviePtr *data.Movie, rowNumber int, matches importMatches, movieValidatorPtr *validator.Validator) ([]string, error) {
+	if len(moviePtr.ExternalIDs) == 0 {
+		return nil, nil
+	}
+	for source, externalID := range moviePtr.ExternalIDs {
+		if row, seen := matches.externalIDs[source+":"+externalID]; seen {
+			movieValidatorPtr.AddError(source, fmt.Sprintf("%s is already in row %d", externalID, row))
+		}
+	}
+	if !movieValidatorPtr.Valid() {
+		return nil, nil
+	}
+
+	movieIDs, err := appPtr.dbModel.MovieExternalIDModel.FindMovieIDs(moviePtr.ExternalIDs)
+	if err != nil {
+		return nil, err
+	}
+	var currentGenres []string
+	switch {
+	case len(movieIDs) > 1:
+		movieValidatorPtr.AddError("external_ids", fmt.Sprintf("belong to different movies %v", movieIDs))
+		return nil, nil
+	case len(movieIDs) == 1:
+		if row, seen := matches.movies[movieIDs[0]]; seen {
+			movieValidatorPtr.AddError("external_ids", fmt.Sprintf("match movie %d, already updated by row %d", movieIDs[0], row))
+			return nil, nil
+		}
+		currentMovies, err := appPtr.dbModel.MovieModel.GetMovies(movieIDs)
+		if err != nil {
+			return nil, err
+		}
+		currentMoviePtr, exists := currentMovies[movieIDs[0]]
+		if !exists {
+			movieValidatorPtr.AddError("external_ids", fmt.Sprintf("match movie %d, which is in the trash", movieIDs[0]))
+			return nil, nil
+		}
+		moviePtr.ID, moviePtr.Version = currentMoviePtr.ID, currentMoviePtr.Version
+		currentGenres = currentMoviePtr.Genres
+		moviePtr.ReleaseYear = currentMoviePtr.ReleaseYear
+		if moviePtr.Status == "" {
+			moviePtr.Status = currentMoviePtr.Status
+		}
+		matches.movies[moviePtr.ID] = rowNumber
+	}
+
+	for source, externalID := range moviePtr.ExternalIDs {
+		matches.externalIDs[source+":"+externalID] = rowNumber
+	}
+	return currentGenres, nil
+}
+
+// importBatchErrorResponse reports a batch the database refused, or a lookup that failed while a row was read. A
+// movie that changed or an external id taken while the import was running only happen when someone else edits the
+// same movies meanwhile, the client can run the import again
+func (appPtr *application) importBatchErrorResponse(w http.ResponseWriter, r *http.Request, err error, reportPtr *importReport) {
+	switch {
+	case errors.Is(err, data.ErrEditConflict):
+		appPtr.importErrorResponse(w, r, http.StatusConflict, "trying to update a changed or deleted movie - try again!", reportPtr)
+	case errors.Is(err, data.ErrDuplicateExternalID):
+		appPtr.importErrorResponse(w, r, http.StatusConflict, "an external id of the import was given to another movie while it ran, please try again", reportPtr)
+	default:
+		appPtr.logError(r, err)
+		appPtr.importErrorResponse(w, r, http.StatusInternalServerError, "we encountered a problem in our server", reportPtr)
+	}
+}
+
+// importErrorResponse sends the error that cut an import short along with the report of the rows read until then,
+// so the client knows which movies were already created or updated by the batches inserted before it. Read notes(1)
+func (appPtr *application) importErrorResponse(w http.ResponseWriter, r *http.Request, status int, message any, reportPtr *importReport) {
+	err := appPtr.writeJSON(w, status, envelope{"error": message, "import": reportPtr}, nil)
+	if err != nil {
+		appPtr.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+/*********************************************************************************************************************/
+/*
+ROW READERS
+A movieRowReader hands out the movies in an import body one row at a time. next returns io.EOF once the body has been
+read, an importRowError for a row that can't be parsed (we report it and carry on with the next row), or any other
+error when the body can't be read any further.
+*/
+type movieRowReader interface {
+	next() (importInput, error)
+}
+
+// importInput is a row of an import: a movie as POST /v1/movies takes it, along with the ids other movie databases
+// know it by e.g. {"imdb": "tt0111161"}
+type importInput struct {
+	data.MovieInput
+	ExternalIDs map[string]string `json:"external_ids"`
+}
+
+var errUnsupportedImportFormat = errors.New("import body must be text/csv or application/x-ndjson")
+
+type importRowError struct {
+	message string
+}
+
+func (rowErr importRowError) Error() string {
+	return rowErr.message
+}
+
+// newMovieRowReader picks the reader for the request's Content-Type
+func newMovieRowReader(r *http.Request) (movieRowReader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errUnsupportedImportFormat
+	}
+
+	switch mediaType {
+	case "text/csv":
+		return newCSVMovieReader(r.Body)
+	case "application/x-ndjson", "application/ndjson":
+		return newNDJSONMovieReader(r.Body), nil
+	default:
+		return nil, errUnsupportedImportFormat
+	}
+}
+
+/*
+CSV
+The first record is a header naming the title, year, runtime and genres columns, in any order. A movie with several
+genres lists them in one field separated by commas, quoted as usual e.g. "drama,crime". The runtime is written in any
+of the forms the JSON API accepts e.g. 90, "90 mins", "1h30m", "1:30:00" or "PT1H30M" (see data.ParseRuntime). The
+status, imdb_id and tmdb_id columns are optional, an empty status means the movie is released (or, for a movie we
+already have, keeps its status) and an empty id means the movie has no id in that source. The id, version,
+average_rating and review_count columns of a CSV export are read-only: they are accepted so that an export can be
+imported as it is, but ignored, a row is matched to a movie we have by its external ids only.
+*/
+type csvMovieReader struct {
+	readerPtr *csv.Reader
+	columns   map[string]int
+}
+
+var csvImportColumns = []string{"title", "year", "runtime", "genres"}
+
+// the columns a csv may have on top of csvImportColumns
+var csvOptionalColumns = append([]string{"status"}, csvExternalIDColumns...)
+
+// the columns of csvExportColumns a csv may have but that we don't read
+var csvIgnoredColumns = []string{"id", "version", "average_rating", "review_count"}
+
+// the columns of the external ids, <source>_id e.g. imdb_id
+var csvExternalIDColumns = func() []string {
+	columns := make([]string, len(data.ExternalIDSources))
+	for i, source := range data.ExternalIDSources {
+		columns[i] = source + "_id"
+	}
+	return columns
+}()
+
+func newCSVMovieReader(body io.Reader) (*csvMovieReader, error) {
+	readerPtr := csv.NewReader(body)
+	readerPtr.TrimLeadingSpace = true
+	// don't hand us a fresh slice for every record, we copy what we need out of it
+	readerPtr.ReuseRecord = true
+
+	header, err := readerPtr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if validator.PermittedValue(column, csvIgnoredColumns...) {
+			continue
+		}
+		if !validator.PermittedValue(column, append(csvImportColumns, csvOptionalColumns...)...) {
+			return nil, fmt.Errorf(
+				"csv header contains unknown column %q, expected %s",
+				column, strings.Join(append(csvImportColumns, csvOptionalColumns...), ","),
+			)
+		}
+		columns[column] = i
+	}
+	for _, column := range csvImportColumns {
+		if _, exists := columns[column]; !exists {
+			return nil, fmt.Errorf("csv header is missing the %q column", column)
+		}
+	}
+	return &csvMovieReader{readerPtr: readerPtr, columns: columns}, nil
+}
+
+func (csvReaderPtr *csvMovieReader) next() (importInput, error) {
+	record, err := csvReaderPtr.readerPtr.Read()
+	if err != nil {
+		// a malformed record only spoils that record, the csv reader carries on with the next one
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return importInput{}, importRowError{message: parseError.Err.Error()}
+		}
+		return importInput{}, err
+	}
+
+	input := importInput{MovieInput: data.MovieInput{Title: record[csvReaderPtr.columns["title"]]}}
+
+	year, err := strconv.ParseInt(strings.TrimSpace(record[csvReaderPtr.columns["year"]]), 10, 32)
+	if err != nil {
+		return importInput{}, importRowError{message: "year must be an integer"}
+	}
+	input.Year = int32(year)
+
+	input.Runtime, err = data.ParseRuntime(record[csvReaderPtr.columns["runtime"]])
+	if err != nil {
+		return importInput{}, importRowError{message: err.Error()}
+	}
+
+	for _, genre := range strings.Split(record[csvReaderPtr.columns["genres"]], ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			input.Genres = append(input.Genres, genre)
+		}
+	}
+
+	if column, exists := csvReaderPtr.columns["status"]; exists {
+		input.Status = strings.TrimSpace(record[column])
+	}
+
+	for _, source := range data.ExternalIDSources {
+		column, exists := csvReaderPtr.columns[source+"_id"]
+		if !exists {
+			continue
+		}
+		if externalID := strings.TrimSpace(record[column]); externalID != "" {
+			if input.ExternalIDs == nil {
+				input.ExternalIDs = map[string]string{}
+			}
+			input.ExternalIDs[source] = externalID
+		}
+	}
+	return input, nil
+}
+
+/*
+NDJSON
+One JSON movie per line, in exactly the format POST /v1/movies accepts plus an optional "external_ids" object e.g.
+{"title": "Heat", ..., "external_ids": {"imdb": "tt0113277"}}. Blank lines are skipped.
+*/
+type ndjsonMovieReader struct {
+	scannerPtr *bufio.Scanner
+}
+
+func newNDJSONMovieReader(body io.Reader) *ndjsonMovieReader {
+	scannerPtr := bufio.NewScanner(body)
+	// a line is a single movie, the same 1MB readJSON allows for one is plenty
+	scannerPtr.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+	return &ndjsonMovieReader{scannerPtr: scannerPtr}
+}
+
+func (ndjsonReaderPtr *ndjsonMovieReader) next() (importInput, error) {
+	for ndjsonReaderPtr.scannerPtr.Scan() {
+		line := bytes.TrimSpace(ndjsonReaderPtr.scannerPtr.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input importInput
+		lineDecoder := json.NewDecoder(bytes.NewReader(line))
+		lineDecoder.DisallowUnknownFields()
+		if err := lineDecoder.Decode(&input); err != nil {
+			return importInput{}, importRowError{message: err.Error()}
+		}
+		if lineDecoder.More() {
+			return importInput{}, importRowError{message: "line must contain a single JSON movie"}
+		}
+		return input, nil
+	}
+
+	if err := ndjsonReaderPtr.scannerPtr.Err(); err != nil {
+		return importInput{}, err
+	}
+	return importInput{}, io.EOF
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES
+1 - STREAMING THE IMPORT
+We never hold the whole body in memory, only the report and the batch of rows waiting to be inserted. Without
+all_or_nothing a batch is inserted as soon as it is full, so if the import is cut short (a row the database refuses, a
+body that goes over maxImportBytes) the earlier batches stay in the database. The client gets the error along with the
+report of the rows read so far, so it can tell which movies were created or updated before it: their rows are "created"
+or "updated", the rows of the batch that was refused are "failed" and the rows still waiting for their batch are
+"skipped". Rows after the one the import stopped at aren't in the report. With all_or_nothing the valid rows pile up in
+one batch until the body has been read, since we can't know whether to insert anything before we've seen every row.
+
+2 - MATCHING ON EXTERNAL IDS
+Partners sync their catalogues with us by importing them again and again, so a row with an IMDb or TMDB id we already
+know is an update of that movie, not a new one. Every row with external ids is looked up as it is read (see
+matchImportedMovie) and carries the version of the movie we found, so the update is checked against it like any
+other. A row is invalid when its ids point at several movies, at a movie in the trash, or at a movie or an id an
+earlier row of the import already saved, since the last of two such rows would silently win. The ids a row brings are
+added to the movie (replacing the id it had in the same source), ids it leaves out are kept.
+*/
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The formats PATCH /v1/movies/:id accepts, sent back in the Accept-Patch header when the client sends a patch format
+// we don't know
+const acceptedPatchFormats = "application/json, application/merge-patch+json, application/json-patch+json"
+
+var (
+	errUnsupportedPatchFormat = errors.New("body must be " + acceptedPatchFormats)
+	// a JSON Patch "test" operation found a value other than the one it expected
+	errPatchTestFailed = errors.New("the movie does not match a test operation of the patch")
+)
+
+// A patchError is a patch that is well formed but can't be applied to the movie, e.g. it removes a genre the movie
+// doesn't have or leaves the movie in a shape that isn't a movie any more
+type patchError struct {
+	message string
+}
+
+func (err patchError) Error() string {
+	return err.message
+}
+
+// A moviePatch applies the changes a client sent to the movie it is updating
+type moviePatch func(moviePtr *data.Movie) error
+
+/*********************************************************************************************************************/
+// READ MOVIE PATCH
+// readMoviePatch reads the body of PATCH /v1/movies/:id in whichever format its Content-Type names. Read notes(1)
+//   - application/json (or no patch format at all): the fields to change, fields left out stay as they are (read
+//     notes(4) in movies.go)
+//   - application/merge-patch+json: an RFC 7396 merge patch, where null removes a field
+//   - application/json-patch+json: a list of RFC 6902 operations (add, remove, replace and test)
+func (appPtr *application) readMoviePatch(w http.ResponseWriter, r *http.Request) (moviePatch, error) {
+	// a body that isn't labelled as a patch is the plain JSON PATCH has always taken, whatever its Content-Type says
+	// (curl -d sends application/x-www-form-urlencoded), only a patch format we don't know is refused
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.Contains(mediaType, "patch") {
+		mediaType = "application/json"
+	}
+
+	switch mediaType {
+	case "application/json":
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+			Status  *string       `json:"status"`
+		}
+		if err := appPtr.readJSON(w, r, &input); err != nil {
+			return nil, err
+		}
+		return func(moviePtr *data.Movie) error {
+			// Check individual fields if they are nil (if the field is nil, then a value wasn't
+			// provided by the client in the JSON they sent), if so, don't bother updating the value
+			if input.Title != nil {
+				moviePtr.Title = *input.Title
+			}
+			if input.Year != nil {
+				moviePtr.Year = *input.Year
+			}
+			if input.Runtime != nil {
+				moviePtr.Runtime = *input.Runtime
+			}
+			if input.Genres != nil {
+				moviePtr.Genres = input.Genres
+			}
+			if input.Status != nil {
+				moviePtr.Status = *input.Status
+			}
+			return nil
+		}, nil
+
+	case "application/merge-patch+json":
+		var patch any
+		if err := appPtr.readJSON(w, r, &patch); err != nil {
+			return nil, err
+		}
+		return func(moviePtr *data.Movie) error {
+			return patchMovieDocument(moviePtr, func(document any) (any, error) {
+				return mergePatch(document, patch), nil
+			})
+		}, nil
+
+	case "application/json-patch+json":
+		var operations []jsonPatchOperation
+		if err := appPtr.readJSON(w, r, &operations); err != nil {
+			return nil, err
+		}
+		for i, operation := range operations {
+			if !slices.Contains([]string{"add", "remove", "replace", "test"}, operation.Op) {
+				return nil, fmt.Errorf("operation %d: op must be one of add, remove, replace or test", i)
+			}
+			if operation.Op != "remove" && len(operation.Value) == 0 {
+				return nil, fmt.Errorf("operation %d: %s needs a value", i, operation.Op)
+			}
+		}
+		return func(moviePtr *data.Movie) error {
+			return patchMovieDocument(moviePtr, func(document any) (any, error) {
+				return applyJSONPatch(document, operations)
+			})
+		}, nil
+
+	default:
+		return nil, errUnsupportedPatchFormat
+	}
+}
+
+// patchMovieDocument applies a patch to the JSON document of the fields of a movie a client can change, and copies
+// the patched document back into the movie. A patch that touches any other field (e.g. /id or /version) leaves a
+// document that doesn't decode and so fails with a patchError.
+func patchMovieDocument(moviePtr *data.Movie, patch func(document any) (any, error)) error {
+	documentJSON, err := json.Marshal(data.MovieInput{
+		Title:   moviePtr.Title,
+		Year:    moviePtr.Year,
+		Runtime: moviePtr.Runtime,
+		Genres:  moviePtr.Genres,
+		Status:  moviePtr.Status,
+	})
+	if err != nil {
+		return err
+	}
+	var document any
+	if err := json.Unmarshal(documentJSON, &document); err != nil {
+		return err
+	}
+
+	document, err = patch(document)
+	if err != nil {
+		return err
+	}
+
+	patchedJSON, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	// a field the patch removed is left at its zero value, which ValidateMovie will refuse
+	var patched data.MovieInput
+	decoder := json.NewDecoder(bytes.NewReader(patchedJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return patchError{fmt.Sprintf("the patched movie is not a valid movie: %v", err)}
+	}
+
+	moviePtr.Title = patched.Title
+	moviePtr.Year = patched.Year
+	moviePtr.Runtime = patched.Runtime
+	moviePtr.Genres = patched.Genres
+	moviePtr.Status = patched.Status
+	return nil
+}
+
+/*********************************************************************************************************************/
+// JSON MERGE PATCH (RFC 7396)
+// mergePatch merges patch into target: the members of an object patch are merged into the target one by one, null
+// removing a member, and any other patch replaces the target entirely. Arrays are replaced, not merged, so to drop a
+// genre a client sends the genres it wants to keep.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+/*********************************************************************************************************************/
+// JSON PATCH (RFC 6902)
+// A single operation of a JSON Patch e.g. {"op": "add", "path": "/genres/-", "value": "comedy"}. move and copy (which
+// use From) aren't supported, a movie has nothing worth moving or copying between its fields.
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+	From  string          `json:"from"`
+}
+
+// applyJSONPatch applies the operations in order to document and returns the result, stopping at the first one that
+// can't be applied. Nothing of the movie changes unless all of them can.
+func applyJSONPatch(document any, operations []jsonPatchOperation) (any, error) {
+	for i, operation := range operations {
+		tokens, err := parseJSONPointer(operation.Path)
+		if err != nil {
+			return nil, patchError{fmt.Sprintf("operation %d: %v", i, err)}
+		}
+		var value any
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return nil, patchError{fmt.Sprintf("operation %d: %v", i, err)}
+			}
+		}
+
+		switch operation.Op {
+		case "test":
+			current, err := resolveJSONPointer(document, tokens)
+			if err != nil {
+				return nil, patchError{fmt.Sprintf("operation %d: %v", i, err)}
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, errPatchTestFailed
+			}
+			continue
+		case "add", "remove", "replace":
+			// the whole document can only be replaced, and a movie can't be removed by patching it
+			if len(tokens) == 0 {
+				if operation.Op == "remove" {
+					return nil, patchError{fmt.Sprintf("operation %d: the whole movie can't be removed", i)}
+				}
+				document = value
+				continue
+			}
+		}
+
+		parent, err := resolveJSONPointer(document, tokens[:len(tokens)-1])
+		if err != nil {
+			return nil, patchError{fmt.Sprintf("operation %d: %v", i, err)}
+		}
+		last := tokens[len(tokens)-1]
+
+		switch container := parent.(type) {
+		case map[string]any:
+			if _, exists := container[last]; !exists && operation.Op != "add" {
+				return nil, patchError{fmt.Sprintf("operation %d: %s does not exist", i, operation.Path)}
+			}
+			if operation.Op == "remove" {
+				delete(container, last)
+			} else {
+				container[last] = value
+			}
+
+		case []any:
+			index, err := arrayIndex(last, len(container), operation.Op == "add")
+			if err != nil {
+				return nil, patchError{fmt.Sprintf("operation %d: %s: %v", i, operation.Path, err)}
+			}
+			switch operation.Op {
+			case "add":
+				container = append(container[:index], append([]any{value}, container[index:]...)...)
+			case "remove":
+				container = append(container[:index], container[index+1:]...)
+			case "replace":
+				container[index] = value
+			}
+			// the array may have been reallocated, put it back where it came from
+			if document, err = setJSONPointer(document, tokens[:len(tokens)-1], container); err != nil {
+				return nil, patchError{fmt.Sprintf("operation %d: %v", i, err)}
+			}
+
+		default:
+			return nil, patchError{fmt.Sprintf("operation %d: %s is not inside an object or an array", i, operation.Path)}
+		}
+	}
+	return document, nil
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer such as "/genres/0" into its reference tokens, undoing the
+// escaping of "~" (~0) and "/" (~1). The empty pointer refers to the whole document.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must be empty or start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// resolveJSONPointer returns the value the tokens of a JSON Pointer refer to in document
+func resolveJSONPointer(document any, tokens []string) (any, error) {
+	current := document
+	for _, token := range tokens {
+		switch container := current.(type) {
+		case map[string]any:
+			value, exists := container[token]
+			if !exists {
+				return nil, fmt.Errorf("%q does not exist", token)
+			}
+			current = value
+		case []any:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			current = container[index]
+		default:
+			return nil, fmt.Errorf("%q is not inside an object or an array", token)
+		}
+	}
+	return current, nil
+}
+
+// setJSONPointer puts value in document at the place the tokens of a JSON Pointer refer to, which must exist
+func setJSONPointer(document any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := resolveJSONPointer(document, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch container := parent.(type) {
+	case map[string]any:
+		container[last] = value
+	case []any:
+		index, err := arrayIndex(last, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = value
+	}
+	return document, nil
+}
+
+// arrayIndex turns a reference token into an index of an array of the given length. Only add can refer to the end of
+// the array, as its length or as "-".
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	// RFC 6901 doesn't allow leading zeros or signs
+	if err != nil || index < 0 || strconv.Itoa(index) != token {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if index > length || (index == length && !adding) {
+		return 0, fmt.Errorf("index %d is out of bounds", index)
+	}
+	return index, nil
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES
+1 - PATCH FORMATS
+The plain application/json body can't say "remove this genre" (a client has to send every genre it wants to keep) and
+can't tell a field it leaves alone from a field it sets to null. Both standard patch formats are applied to the same
+JSON document, the five fields of a movie a client can change (title, year, runtime, genres and status) exactly as
+GET /v1/movies/:id shows them, and the patched document is decoded back into the movie. So a merge patch of
+{"year": null} leaves a movie with no year and a JSON Patch of [{"op": "remove", "path": "/genres/1"}] drops the second
+genre. Either way the movie then goes through ValidateMovie and UpdateMovie's version check like any other update, a
+movie with no year is refused with a 422 and an update racing another one with a 409. A failed "test" operation is a
+409 as well (RFC 5789 calls it a conflicting state), it is what a client uses to make a change conditional on a value
+it read, e.g. to only rename a genre it saw.
+*/
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON decodes a document written as JSON, the way a patch and the movie it applies to are decoded
+func decodeJSON(t *testing.T, document string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		t.Fatalf("decoding %s: %v", document, err)
+	}
+	return value
+}
+
+func TestArrayIndex(t *testing.T) {
+	tests := []struct {
+		token   string
+		length  int
+		adding  bool
+		want    int
+		wantErr bool
+	}{
+		{token: "0", length: 2, want: 0},
+		{token: "1", length: 2, want: 1},
+		{token: "2", length: 2, wantErr: true},
+		{token: "2", length: 2, adding: true, want: 2},
+		{token: "3", length: 2, adding: true, wantErr: true},
+		{token: "-", length: 2, adding: true, want: 2},
+		{token: "-", length: 2, wantErr: true},
+		{token: "01", length: 2, wantErr: true},
+		{token: "00", length: 2, wantErr: true},
+		{token: "-1", length: 2, wantErr: true},
+		{token: "+1", length: 2, wantErr: true},
+		{token: " 1", length: 2, wantErr: true},
+		{token: "one", length: 2, wantErr: true},
+		{token: "", length: 2, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.token, func(t *testing.T) {
+			index, err := arrayIndex(test.token, test.length, test.adding)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got index %d; want an error", index)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if index != test.want {
+				t.Errorf("got %d; want %d", index, test.want)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{name: "replace a member", target: `{"title": "Moana", "year": 2016}`, patch: `{"year": 2017}`, want: `{"title": "Moana", "year": 2017}`},
+		{name: "null removes a member", target: `{"title": "Moana", "year": 2016}`, patch: `{"year": null}`, want: `{"title": "Moana"}`},
+		{name: "null for a missing member", target: `{"title": "Moana"}`, patch: `{"year": null}`, want: `{"title": "Moana"}`},
+		{name: "arrays are replaced", target: `{"genres": ["animation", "family"]}`, patch: `{"genres": ["family"]}`, want: `{"genres": ["family"]}`},
+		{name: "nested objects are merged", target: `{"a": {"b": 1, "c": 2}}`, patch: `{"a": {"b": null, "d": 3}}`, want: `{"a": {"c": 2, "d": 3}}`},
+		{name: "object over a scalar", target: `{"a": 1}`, patch: `{"a": {"b": 2}}`, want: `{"a": {"b": 2}}`},
+		{name: "non-object patch replaces the target", target: `{"title": "Moana"}`, patch: `["x"]`, want: `["x"]`},
+		{name: "empty patch", target: `{"title": "Moana"}`, patch: `{}`, want: `{"title": "Moana"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := mergePatch(decodeJSON(t, test.target), decodeJSON(t, test.patch))
+			if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	const movie = `{"title": "Moana", "year": 2016, "genres": ["animation", "family"], "a/b": 1, "m~n": 2}`
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{name: "replace a field", patch: `[{"op": "replace", "path": "/year", "value": 2017}]`, want: `{"title": "Moana", "year": 2017, "genres": ["animation", "family"], "a/b": 1, "m~n": 2}`},
+		{name: "remove a field", patch: `[{"op": "remove", "path": "/year"}]`, want: `{"title": "Moana", "genres": ["animation", "family"], "a/b": 1, "m~n": 2}`},
+		{name: "add a field", patch: `[{"op": "add", "path": "/status", "value": "released"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["animation", "family"], "status": "released", "a/b": 1, "m~n": 2}`},
+		{name: "append with -", patch: `[{"op": "add", "path": "/genres/-", "value": "musical"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["animation", "family", "musical"], "a/b": 1, "m~n": 2}`},
+		{name: "append at the length", patch: `[{"op": "add", "path": "/genres/2", "value": "musical"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["animation", "family", "musical"], "a/b": 1, "m~n": 2}`},
+		{name: "insert at the start", patch: `[{"op": "add", "path": "/genres/0", "value": "musical"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["musical", "animation", "family"], "a/b": 1, "m~n": 2}`},
+		{name: "remove an element", patch: `[{"op": "remove", "path": "/genres/0"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["family"], "a/b": 1, "m~n": 2}`},
+		{name: "replace an element", patch: `[{"op": "replace", "path": "/genres/1", "value": "musical"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["animation", "musical"], "a/b": 1, "m~n": 2}`},
+		{name: "escaped slash and tilde", patch: `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["animation", "family"], "a/b": 3}`},
+		{name: "operations apply in order", patch: `[{"op": "remove", "path": "/genres/0"}, {"op": "replace", "path": "/genres/0", "value": "musical"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["musical"], "a/b": 1, "m~n": 2}`},
+		{name: "passing test", patch: `[{"op": "test", "path": "/genres/1", "value": "family"}, {"op": "remove", "path": "/genres/1"}]`, want: `{"title": "Moana", "year": 2016, "genres": ["animation"], "a/b": 1, "m~n": 2}`},
+		{name: "replace the whole document", patch: `[{"op": "replace", "path": "", "value": {"title": "Up"}}]`, want: `{"title": "Up"}`},
+		{name: "failing test", patch: `[{"op": "test", "path": "/year", "value": 2017}]`, wantErr: errPatchTestFailed},
+		{name: "test compares types", patch: `[{"op": "test", "path": "/year", "value": "2016"}]`, wantErr: errPatchTestFailed},
+		{name: "leading zero index", patch: `[{"op": "remove", "path": "/genres/01"}]`, wantErr: patchError{}},
+		{name: "negative index", patch: `[{"op": "remove", "path": "/genres/-1"}]`, wantErr: patchError{}},
+		{name: "- outside add", patch: `[{"op": "replace", "path": "/genres/-", "value": "musical"}]`, wantErr: patchError{}},
+		{name: "index out of bounds", patch: `[{"op": "add", "path": "/genres/3", "value": "musical"}]`, wantErr: patchError{}},
+		{name: "remove a missing field", patch: `[{"op": "remove", "path": "/runtime"}]`, wantErr: patchError{}},
+		{name: "replace a missing field", patch: `[{"op": "replace", "path": "/runtime", "value": 107}]`, wantErr: patchError{}},
+		{name: "path without a slash", patch: `[{"op": "remove", "path": "year"}]`, wantErr: patchError{}},
+		{name: "path through a scalar", patch: `[{"op": "add", "path": "/year/x", "value": 1}]`, wantErr: patchError{}},
+		{name: "remove the whole document", patch: `[{"op": "remove", "path": ""}]`, wantErr: patchError{}},
+		{name: "test a missing field", patch: `[{"op": "test", "path": "/runtime", "value": 107}]`, wantErr: patchError{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var operations []jsonPatchOperation
+			if err := json.Unmarshal([]byte(test.patch), &operations); err != nil {
+				t.Fatalf("decoding the patch: %v", err)
+			}
+
+			got, err := applyJSONPatch(decodeJSON(t, movie), operations)
+			switch test.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if want := decodeJSON(t, test.want); !reflect.DeepEqual(got, want) {
+					t.Errorf("got %v; want %v", got, want)
+				}
+			case patchError:
+				var patchErr patchError
+				if !errors.As(err, &patchErr) {
+					t.Errorf("got %v, %v; want a patchError", got, err)
+				}
+			default:
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got %v, %v; want %v", got, err, test.wantErr)
+				}
+			}
+		})
+	}
+}
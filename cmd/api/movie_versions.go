@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/*********************************************************************************************************************/
+//GET /v1/movies/:id/versions
+//To list the revision history of a movie, newest version first
+func (appPtr *application) listMovieVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
+	filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
+	filters.Sort = "-version"
+	filters.SortSafeList = []string{"-version"}
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of every version is written
+	runtimeFormat := appPtr.readRuntimeFormat(queryString, queryValidatorPtr)
+
+	if data.ValidateFilters(queryValidatorPtr, filters); !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	versionPtrs, metadata, err := appPtr.dbModel.MovieVersionModel.GetAllForMovie(moviePtr.ID, filters)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "versions": presentMovieVersions(versionPtrs, runtimeFormat)}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//GET /v1/movies/:id/versions/:version
+//To get a movie as it was at a given version
+func (appPtr *application) showMovieVersionHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		appPtr.badRequestResponse(w, r, errors.New("read version: invalid version parameter"))
+		return
+	}
+
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the version is written
+	queryValidatorPtr := validator.New()
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	movieVersionPtr, err := appPtr.dbModel.MovieVersionModel.GetVersion(moviePtr.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"version": presentMovieVersion(*movieVersionPtr, runtimeFormat)}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//POST /v1/movies/:id/revert?version=N
+//To put a movie back the way it was at an earlier version. The revert is an update like any other: it is validated
+//against the genres in use today, it is saved as a new version and it fails with an edit conflict if the movie
+//changed while we were reverting it
+func (appPtr *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	queryValidatorPtr := validator.New()
+	version := appPtr.readInt(r.URL.Query(), "version", 0, queryValidatorPtr)
+	queryValidatorPtr.Check(version > 0, "version", "must be provided and be a positive integer")
+	queryValidatorPtr.Check(
+		version < int(moviePtr.Version),
+		"version",
+		fmt.Sprintf("must be earlier than the movie's current version (%d)", moviePtr.Version),
+	)
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	movieVersionPtr, err := appPtr.dbModel.MovieVersionModel.GetVersion(moviePtr.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the genres it has now, which it can keep even if they have been retired since
+	currentGenres := moviePtr.Genres
+
+	moviePtr.Title = movieVersionPtr.Title
+	// a movie with release dates keeps the year of its first release, read notes(13) in internal/data/movies.go
+	if moviePtr.ReleaseYear == 0 {
+		moviePtr.Year = movieVersionPtr.Year
+	}
+	moviePtr.Runtime = movieVersionPtr.Runtime
+	moviePtr.Genres = movieVersionPtr.Genres
+	moviePtr.Status = movieVersionPtr.Status
+
+	// A genre the old version was tagged with may have been retired since, it can only come back if the movie
+	// still has it
+	activeGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movieValidatorPtr := validator.New()
+	data.ValidateMovie(movieValidatorPtr, moviePtr, data.GenresForEdit(activeGenres, currentGenres))
+	if !movieValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, movieValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.MovieModel.UpdateMovie(moviePtr, appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"greenlight-movie-api/internal/data"
 	"greenlight-movie-api/internal/validator"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 )
 
 /*********************************************************************************************************************/
 //POST /v1/movies
-//To create a new movie
+//To create a new movie, unless we already have a movie with the same title and year, read notes(12)
+//in internal/data/movies.go
 func (appPtr *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	//Create a new movie input struct
 	var input data.MovieInput
@@ -29,19 +34,56 @@ func (appPtr *application) createMovieHandler(w http.ResponseWriter, r *http.Req
 		Runtime: input.Runtime,
 		Genres:  input.Genres,
 		Title:   input.Title,
+		Status:  input.Status,
+	}
+	// a movie is taken to be released unless the client says it isn't out yet
+	if movie.Status == "" {
+		movie.Status = data.MovieStatusReleased
+	}
+
+	// The genres a movie can be tagged with are managed in the database, this
+	// is served from a cache so it doesn't cost us a query on every request
+	allowedGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// Validate the input from the movie input send a
 	// failedValidationResponse if any errors encountered during validation
 	movieValidatorPtr := validator.New()
 
-	data.ValidateMovie(movieValidatorPtr, &movie)
+	data.ValidateMovie(movieValidatorPtr, &movie, allowedGenres)
+	// ?allow_duplicate=true creates the movie even though it looks like one we already have
+	allowDuplicate := appPtr.readBool(r.URL.Query(), "allow_duplicate", movieValidatorPtr)
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), movieValidatorPtr)
 	if !movieValidatorPtr.Valid() {
 		appPtr.failedValidationResponse(w, r, movieValidatorPtr.Errors)
 		return
 	}
-	//Store the movie in our database
-	err = appPtr.dbModel.MovieModel.InsertMovie(&movie)
+
+	// Store the movie in our database. A movie with the same (normalized) title and year is most likely
+	// the same movie, we refuse it unless the client overrides us, and even then we tell it which movies
+	// it looks like. The check and the insert are one transaction, read notes(12) in internal/data/movies.go
+	duplicatePtrs, err := appPtr.dbModel.MovieModel.CreateMovie(
+		&movie, appPtr.contextGetUser(r).ID, allowDuplicate != nil && *allowDuplicate,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			duplicates, err := presentMovies(duplicatePtrs, runtimeFormat)
+			if err != nil {
+				appPtr.serverErrorResponse(w, r, err)
+				return
+			}
+			appPtr.duplicateMovieResponse(w, r, duplicates)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	duplicates, err := presentMovies(duplicatePtrs, runtimeFormat)
 	if err != nil {
 		appPtr.serverErrorResponse(w, r, err)
 		return
@@ -51,12 +93,22 @@ func (appPtr *application) createMovieHandler(w http.ResponseWriter, r *http.Req
 	// client know which URL they can find the newly-created resource at.
 	headers := http.Header{}
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(&movie))
 
 	//Return a response to the user that the movie was created successfully
 	//the movie we are sending back will actually have been updated with the
 	//fields that were erstwhile empty from the client, these fields have been
 	//populated by our database and updated in the movie now being sent back
-	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	createdMovie, err := presentMovie(movie, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	createdMovieData := envelope{"movie": createdMovie}
+	if len(duplicates) > 0 {
+		createdMovieData["duplicates"] = duplicates
+	}
+	err = appPtr.writeJSON(w, http.StatusCreated, createdMovieData, headers)
 	if err != nil {
 		appPtr.serverErrorResponse(w, r, err)
 	}
@@ -75,6 +127,23 @@ func (appPtr *application) showMovieHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// ?include=credits embeds the directors, writers and cast in the movie
+	queryValidatorPtr := validator.New()
+	include := appPtr.readCSV(r.URL.Query(), "include", []string{}, []string{"credits"}, queryValidatorPtr)
+	// ?fields=id,title cuts the movie down to those fields, the releases, images and external ids only come
+	// with it when they are picked too (or no fields are given), they aren't even read otherwise
+	fields := appPtr.readCSV(r.URL.Query(), "fields", []string{}, nil, nil)
+	data.ValidateFields(queryValidatorPtr, fields, data.MovieShowFieldSafeList)
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+	shows := func(field string) bool {
+		return len(fields) == 0 || slices.Contains(fields, field)
+	}
+
 	// Call the Get() method to fetch the data for a specific movie. We also need to
 	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
 	// error, in which case we send a 404 Not Found response to the client
@@ -90,11 +159,84 @@ func (appPtr *application) showMovieHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// The title is shown in the client's language, so caches must keep a copy per language. Added
+	// rather than passed to writeJSON, which would replace the Vary: Authorization already set
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = appPtr.localizeMovie(moviePtr, data.LanguageFallbacks(acceptedLanguages(r)))
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if slices.Contains(include, "credits") {
+		moviePtr.Credits, err = appPtr.dbModel.CreditModel.GetAllForMovie(moviePtr.ID)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// the dates it comes out in each country come with it as well, read notes(13) in internal/data/movies.go
+	if shows("releases") {
+		moviePtr.Releases, err = appPtr.dbModel.MovieReleaseModel.GetAllForMovie(moviePtr.ID)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// and the posters and stills, with the URLs to fetch them from
+	if shows("images") {
+		moviePtr.Images, err = appPtr.dbModel.MovieImageModel.GetAllForMovie(moviePtr.ID)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, imagePtr := range moviePtr.Images {
+			appPtr.setImageURLs(imagePtr)
+		}
+	}
+
+	// and the ids other movie databases know it by
+	if shows("external_ids") {
+		moviePtr.ExternalIDs, err = appPtr.dbModel.MovieExternalIDModel.GetAllForMovie(moviePtr.ID)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// the credits the client asked to include stay in, whatever fields it picked
+	if len(fields) > 0 && slices.Contains(include, "credits") {
+		fields = append(fields, "credits")
+	}
+	movie, err := presentMovie(*moviePtr, fields, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
 	//wrap the movie data with the string "movie"
-	wrappedMovieData := envelope{"movie": *moviePtr}
+	wrappedMovieData := envelope{"movie": movie}
+
+	// The ETag covers the version and everything shown with the movie, a client that already has
+	// this very response gets a 304 with no body instead of the movie all over again. Read notes(6)
+	etag, err := movieRepresentationETag(moviePtr, wrappedMovieData)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	headers := http.Header{}
+	headers.Set("ETag", etag)
 
 	//marshal the movie data into json and send to the client
-	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, nil)
+	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, headers)
 
 	//Respond with an error if we encountered an error marshalling the movie data into valid json
 	if err != nil {
@@ -110,19 +252,17 @@ func (appPtr *application) showMovieHandler(w http.ResponseWriter, r *http.Reque
 //To update a field in a specific movie
 //Refer to notes(4) for more info on how null json values behave
 func (appPtr *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
-	//Create a new movie input struct
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	//Unmarshal the JSON from request body into the input struct
-	//Send a bad request response if any error during unmarshaling
-	err := appPtr.readJSON(w, r, &input)
+	//Read the changes from the request body, as plain JSON, a JSON Merge Patch or a JSON Patch
+	//depending on its Content-Type. Send a bad request response if the body can't be read
+	patch, err := appPtr.readMoviePatch(w, r)
 	if err != nil {
-		appPtr.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, errUnsupportedPatchFormat):
+			w.Header().Set("Accept-Patch", acceptedPatchFormats)
+			appPtr.errorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+		default:
+			appPtr.badRequestResponse(w, r, err)
+		}
 		return
 	}
 
@@ -150,33 +290,46 @@ func (appPtr *application) updateMovieHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Change the values of the movie we got back from the db to the new values
-	// provided in the input from the request. Check individual fields if they
-	// are nil (if the field is nil, then a value wasn't provided by the client
-	// in the JSON they sent), if so, don't bother updating the value in the moviePtr
-	// moviePtr.Title = input.Title
-	// moviePtr.Year = input.Year
-	// moviePtr.Runtime = input.Runtime
-	// moviePtr.Genres = input.Genres
-
-	if input.Title != nil {
-		moviePtr.Title = *input.Title
-	}
-	if input.Year != nil {
-		moviePtr.Year = *input.Year
+	// If-Match lets a client make the update conditional on the version it last saw, read notes(6)
+	if appPtr.ifMatchFails(r, moviePtr) {
+		appPtr.preconditionFailedResponse(w, r)
+		return
 	}
-	if input.Runtime != nil {
-		moviePtr.Runtime = *input.Runtime
+
+	// the genres it has now, which it can keep even if they have been retired since
+	currentGenres := moviePtr.Genres
+
+	// Change the values of the movie we got back from the db to the new values
+	// provided in the request, see readMoviePatch
+	err = patch(moviePtr)
+	if err != nil {
+		var patchErr patchError
+		switch {
+		case errors.Is(err, errPatchTestFailed):
+			appPtr.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.As(err, &patchErr):
+			appPtr.errorResponse(w, r, http.StatusUnprocessableEntity, patchErr.Error())
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if input.Genres != nil {
-		moviePtr.Genres = input.Genres
+
+	// The genres a movie can be tagged with are managed in the database, this
+	// is served from a cache so it doesn't cost us a query on every request
+	activeGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// Validate the input from the movie input send a
 	// failedValidationResponse if any errors encountered during validation
 	movieValidatorPtr := validator.New()
 
-	data.ValidateMovie(movieValidatorPtr, moviePtr)
+	data.ValidateMovie(movieValidatorPtr, moviePtr, data.GenresForEdit(activeGenres, currentGenres))
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), movieValidatorPtr)
 	if !movieValidatorPtr.Valid() {
 		appPtr.failedValidationResponse(w, r, movieValidatorPtr.Errors)
 		return
@@ -187,9 +340,13 @@ func (appPtr *application) updateMovieHandler(w http.ResponseWriter, r *http.Req
 	//Although this shouldn't happen, since the id we're using for the update was got from
 	//the DB itself. We send a serverErrorResponse if we encountered any error updating the
 	//resource successfully in the DB
-	err = appPtr.dbModel.MovieModel.UpdateMovie(moviePtr)
+	err = appPtr.dbModel.MovieModel.UpdateMovie(moviePtr, appPtr.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		// the movie changed between our read and our write, for a client that sent If-Match
+		// that means the version it expected is gone
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			appPtr.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			appPtr.editConflictResponse(w, r)
 		default:
@@ -199,11 +356,19 @@ func (appPtr *application) updateMovieHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	//RETURN THE UPDATED MOVIE
+	movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
 	//wrap the movie data with the string "movie"
-	wrappedMovieData := envelope{"movie": *moviePtr}
+	wrappedMovieData := envelope{"movie": movie}
 
-	//marshal the movie data into json and send to the client
-	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, nil)
+	//marshal the movie data into json and send to the client, along with the
+	//new version's ETag
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, headers)
 
 	//Respond with an error if we encountered an error marshalling the movie data into valid json
 	if err != nil {
@@ -253,18 +418,41 @@ func (appPtr *application) replaceMovieHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// If-Match lets a client make the update conditional on the version it last saw, read notes(6)
+	if appPtr.ifMatchFails(r, moviePtr) {
+		appPtr.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// the genres it has now, which it can keep even if they have been retired since
+	currentGenres := moviePtr.Genres
+
 	// Change the values of the movie we got back from the db to the new values
 	// provided in the input from the request.
 	moviePtr.Title = input.Title
 	moviePtr.Year = input.Year
 	moviePtr.Runtime = input.Runtime
 	moviePtr.Genres = input.Genres
+	// clients that don't know about the status yet leave it as it is
+	if input.Status != "" {
+		moviePtr.Status = input.Status
+	}
+
+	// The genres a movie can be tagged with are managed in the database, this
+	// is served from a cache so it doesn't cost us a query on every request
+	activeGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
 
 	// Validate the input from the movie input send a
 	// failedValidationResponse if any errors encountered during validation
 	movieValidatorPtr := validator.New()
 
-	data.ValidateMovie(movieValidatorPtr, moviePtr)
+	data.ValidateMovie(movieValidatorPtr, moviePtr, data.GenresForEdit(activeGenres, currentGenres))
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), movieValidatorPtr)
 	if !movieValidatorPtr.Valid() {
 		appPtr.failedValidationResponse(w, r, movieValidatorPtr.Errors)
 		return
@@ -275,11 +463,13 @@ func (appPtr *application) replaceMovieHandler(w http.ResponseWriter, r *http.Re
 	//Although this shouldn't happen, since the id we're using for the update was got from
 	//the DB itself. We send a serverErrorResponse if we encountered any error updating the
 	//resource successfully in the DB
-	err = appPtr.dbModel.MovieModel.UpdateMovie(moviePtr)
+	err = appPtr.dbModel.MovieModel.UpdateMovie(moviePtr, appPtr.contextGetUser(r).ID)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			appPtr.notFoundHandler(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			appPtr.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
 		default:
 			appPtr.serverErrorResponse(w, r, err)
 		}
@@ -287,11 +477,19 @@ func (appPtr *application) replaceMovieHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	//RETURN THE UPDATED MOVIE
+	movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
 	//wrap the movie data with the string "movie"
-	wrappedMovieData := envelope{"movie": *moviePtr}
+	wrappedMovieData := envelope{"movie": movie}
 
-	//marshal the movie data into json and send to the client
-	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, nil)
+	//marshal the movie data into json and send to the client, along with the
+	//new version's ETag
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, headers)
 
 	//Respond with an error if we encountered an error marshalling the movie data into valid json
 	if err != nil {
@@ -315,9 +513,17 @@ func (appPtr application) deleteMovieHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	//Delete the movie from the DB
-	moviePtr, err := appPtr.dbModel.MovieModel.Delete(id)
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	queryValidatorPtr := validator.New()
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
 
+	// Read the movie first so that we can honour If-Match, and so that the delete only goes
+	// through if the movie hasn't changed since then
+	moviePtr, err := appPtr.dbModel.MovieModel.GetMovie(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -327,8 +533,34 @@ func (appPtr application) deleteMovieHandler(w http.ResponseWriter, r *http.Requ
 		}
 		return
 	}
+	if appPtr.ifMatchFails(r, moviePtr) {
+		appPtr.preconditionFailedResponse(w, r)
+		return
+	}
+
+	//Move the movie to the trash, recording who deleted it
+	moviePtr, err = appPtr.dbModel.MovieModel.Delete(id, moviePtr.Version, appPtr.contextGetUser(r).ID)
 
-	wrappedMovieData := envelope{"deleteOK": true, "movie": *moviePtr}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			appPtr.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+	wrappedMovieData := envelope{"deleteOK": true, "movie": movie}
 	err = appPtr.writeJSON(w, http.StatusOK, wrappedMovieData, nil)
 
 	if err != nil {
@@ -342,29 +574,39 @@ func (appPtr *application) showAllMoviesHandler(w http.ResponseWriter, r *http.R
 	//we'll define an input struct
 	//to hold the expected values from the request query string.
 	var input struct {
-		Title   string
-		Genres  []string
+		data.MovieQuery
 		Filters data.Filters
 	}
 
 	queryString := r.URL.Query()
 	queryValidatorPtr := validator.New()
 
-	// Use our helpers to extract the title and genres query string values, falling back
-	// to defaults of an empty string and an empty slice respectively if they are not
-	// provided by the client.
-	input.Title = appPtr.readString(queryString, "title", "")
-	input.Genres = appPtr.readCSV(queryString, "genres", []string{}, data.AllowedGenres, queryValidatorPtr)
+	allowedGenres, err := appPtr.dbModel.GenreModel.ActiveGenreNames()
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The title, genres, people, ranges and sort are shared with the export
+	input.MovieQuery, input.Filters = appPtr.readMovieQuery(queryString, allowedGenres, queryValidatorPtr)
+	// Every title is shown in the best of the client's languages, see localizeMovie
+	input.MovieQuery.Languages = data.LanguageFallbacks(acceptedLanguages(r))
 
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
 	input.Filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
 	input.Filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
-	input.Filters.Sort = appPtr.readString(queryString, "sort", "id")
-
-	//the values we allow to be provided as a value for the input.Filters.Sort field
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	// The opaque cursor from a previous page's next_cursor. When it is provided we page
+	// with it (keyset pagination) instead of the page number.
+	input.Filters.Cursor = appPtr.readString(queryString, "cursor", "")
+	// ?fields=id,title cuts every movie down to those fields, checked against the safelist in ValidateFilters
+	input.Filters.Fields = appPtr.readCSV(queryString, "fields", []string{}, nil, nil)
+	input.Filters.FieldSafeList = data.MovieFieldSafeList
+	// ?facets=genres,decade counts the matching movies in each genre and decade
+	facets := appPtr.readCSV(queryString, "facets", []string{}, data.MovieFacets, queryValidatorPtr)
+	// ?runtime_format=minutes|human|iso8601 picks how the runtimes are written
+	runtimeFormat := appPtr.readRuntimeFormat(queryString, queryValidatorPtr)
 
 	data.ValidateFilters(queryValidatorPtr, input.Filters)
 
@@ -379,46 +621,324 @@ func (appPtr *application) showAllMoviesHandler(w http.ResponseWriter, r *http.R
 	// At this point, we're 100% certain that whatever was
 	// passed in the filter is valid, this is particularly important as in the GetAllMovies function
 	// that is called, we don't do any safety checks on the filters - especially the sort field values.
-	moviesPtrs, err := appPtr.dbModel.MovieModel.GetAllMovies(input.Title, input.Genres, input.Filters)
+	moviesPtrs, metadata, err := appPtr.dbModel.MovieModel.GetAllMovies(input.MovieQuery, input.Filters)
 	if err != nil {
 		appPtr.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// A title search that found nothing at all is most likely a typo, suggest the titles
+	// closest to it. Read notes(8) in internal/data/movies.go
+	if len(moviesPtrs) == 0 && input.MovieQuery.Title != "" && input.Filters.Cursor == "" && input.Filters.Page == 1 {
+		metadata.DidYouMean, err = appPtr.dbModel.MovieModel.SuggestTitles(input.MovieQuery.Title, 5)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	//dereference the individual moviePtrs in the moviesPtrs slice
 	//add the actual movie to the moviesSlice. Note however that this
 	//dereferencing is not necessary and is only here for clarity sake
-	//Refer to Notes(5) for more on this
-	moviesSlice := []data.Movie{}
+	//Refer to Notes(5) for more on this. Each movie is cut down to the fields the client
+	//asked for, if it asked for any, with its runtime in the format the client asked for
+	moviesSlice := []any{}
 	for _, moviePtr := range moviesPtrs {
-		moviesSlice = append(moviesSlice, *moviePtr)
+		movie, err := presentMovie(*moviePtr, input.Filters.Fields, runtimeFormat)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+		moviesSlice = append(moviesSlice, movie)
 	}
 
-	//total number of movies returned by the array including the
-	//genre and title filters, not regarding the limit and offset
-	//clauses.
-	var totalRecords int
-	if len(moviesSlice) == 0 {
-		totalRecords = 0
-	} else {
-		totalRecords = moviesSlice[0].TotalMovies
+	moviesData := envelope{
+		"metadata": metadata,
+		"movies":   moviesSlice,
 	}
 
-	moviesData := envelope{
-		"metadata": data.CalculatePageMetadata(
-			totalRecords,
-			input.Filters.PageSize,
-			input.Filters.Page,
-		),
-		"movies": moviesSlice,
+	// The facets are counted apart from the page, read notes(10) in internal/data/movies.go
+	if len(facets) > 0 {
+		moviesData["facets"], err = appPtr.dbModel.MovieModel.GetFacets(input.MovieQuery, input.Filters, facets)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
+	w.Header().Add("Vary", "Accept-Language")
 	err = appPtr.writeJSON(w, http.StatusOK, moviesData, nil)
 	if err != nil {
 		appPtr.serverErrorResponse(w, r, err)
 	}
 }
 
+/*********************************************************************************************************************/
+// READ MOVIE QUERY
+// readMovieQuery reads what a movie listing is searched, filtered and sorted by from the query string. It is shared
+// by GET /v1/movies and GET /v1/movies/export so the two always accept the same parameters, the pagination is left
+// to the caller. Problems are recorded in queryValidatorPtr, though the caller still has to run ValidateFilters.
+func (appPtr *application) readMovieQuery(queryString url.Values, allowedGenres []string, queryValidatorPtr *validator.Validator) (data.MovieQuery, data.Filters) {
+	var movieQuery data.MovieQuery
+	var filters data.Filters
+
+	// Use our helpers to extract the title and genres query string values, falling back
+	// to defaults of an empty string and an empty slice respectively if they are not
+	// provided by the client.
+	movieQuery.Title = appPtr.readString(queryString, "title", "")
+	movieQuery.Genres = appPtr.readCSV(queryString, "genres", []string{}, allowedGenres, queryValidatorPtr)
+	// Only list movies with a director or an actor whose name matches e.g. ?director=nolan
+	movieQuery.Director = appPtr.readString(queryString, "director", "")
+	movieQuery.Actor = appPtr.readString(queryString, "actor", "")
+	// ?fuzzy=true forgives typos in the title e.g. ?title=godfater&fuzzy=true
+	if fuzzy := appPtr.readBool(queryString, "fuzzy", queryValidatorPtr); fuzzy != nil {
+		movieQuery.Fuzzy = *fuzzy
+	}
+	queryValidatorPtr.Check(!movieQuery.Fuzzy || movieQuery.Title != "", "fuzzy", "can only be used together with a title")
+
+	// ?status=announced,in_production lists what is coming, ?region=GB&released_after=2024-01-31 what came out in
+	// Britain since. Read notes(13) in internal/data/movies.go
+	movieQuery.Statuses = appPtr.readCSV(queryString, "status", []string{}, data.MovieStatuses, queryValidatorPtr)
+	movieQuery.Region = strings.ToUpper(appPtr.readString(queryString, "region", ""))
+	queryValidatorPtr.Check(
+		movieQuery.Region == "" || validator.Matches(movieQuery.Region, data.RegionRX),
+		"region",
+		"must be a two letter country code e.g. GB",
+	)
+	if releasedAfter := appPtr.readString(queryString, "released_after", ""); releasedAfter != "" {
+		date, err := time.Parse(time.DateOnly, releasedAfter)
+		if err != nil {
+			queryValidatorPtr.AddError("released_after", "must be a date in the form 2006-01-02")
+		}
+		movieQuery.ReleasedAfter = date
+	}
+
+	// The year_min/year_max and runtime_min/runtime_max ranges e.g. "90s dramas under two
+	// hours" is ?genres=drama&year_min=1990&year_max=1999&runtime_max=120
+	filters.Ranges = map[string]data.Range{
+		"year":    appPtr.readRange(queryString, "year", queryValidatorPtr),
+		"runtime": appPtr.readRange(queryString, "runtime", queryValidatorPtr),
+	}
+
+	filters.Sort = appPtr.readString(queryString, "sort", "id")
+	//the values we allow to be provided as a value for the filters.Sort field
+	filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime", "relevance", "rating", "-rating"}
+
+	// relevance is how well a title matches the title search, without one every movie is equally relevant
+	queryValidatorPtr.Check(
+		filters.Sort != "relevance" || movieQuery.Title != "",
+		"sort",
+		"relevance can only be used together with a title",
+	)
+	return movieQuery, filters
+}
+
+/*********************************************************************************************************************/
+// GET /v1/movies/:id/similar
+// To list the movies most like a movie ("more like this"), by their genres, year and runtime. Read notes(9) in
+// internal/data/movies.go
+func (appPtr *application) showSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
+	filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
+	filters.Sort = "-similarity"
+	filters.SortSafeList = []string{"-similarity"}
+	runtimeFormat := appPtr.readRuntimeFormat(queryString, queryValidatorPtr)
+
+	if data.ValidateFilters(queryValidatorPtr, filters); !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	moviePtrs, metadata, err := appPtr.dbModel.MovieModel.GetSimilar(moviePtr, filters)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, err := presentMovies(moviePtrs, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+// GET /v1/movies/trash
+// To list the deleted movies that haven't been purged yet, most recently deleted first by default
+func (appPtr *application) showTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
+	filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
+	filters.Sort = appPtr.readString(queryString, "sort", "-deleted_at")
+	filters.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+	runtimeFormat := appPtr.readRuntimeFormat(queryString, queryValidatorPtr)
+
+	if data.ValidateFilters(queryValidatorPtr, filters); !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	moviePtrs, metadata, err := appPtr.dbModel.MovieModel.GetAllDeleted(filters)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies, err := presentMovies(moviePtrs, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+// POST /v1/movies/:id/restore
+// To take a deleted movie back out of the trash
+func (appPtr *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	queryValidatorPtr := validator.New()
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), queryValidatorPtr)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	moviePtr, err := appPtr.dbModel.MovieModel.Restore(id, appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+// POST /v1/movies/:id/merge
+// To fold a duplicate into this movie e.g. POST /v1/movies/1/merge {"duplicate_id": 7, "duplicate_version": 2}. The
+// duplicate's reviews, credits, watchlist entries, images, translations, external ids and release dates move to the
+// movie, which gets a new version, and the duplicate goes to the trash, all at once. duplicate_version is optional,
+// when given the merge only goes through if the duplicate is still at that version. Read notes(12) in
+// internal/data/movies.go
+func (appPtr *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		DuplicateID      int64  `json:"duplicate_id"`
+		DuplicateVersion *int32 `json:"duplicate_version"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	mergeValidatorPtr := validator.New()
+	mergeValidatorPtr.Check(input.DuplicateID > 0, "duplicate_id", "must be the id of a movie")
+	mergeValidatorPtr.Check(input.DuplicateID != moviePtr.ID, "duplicate_id", "must not be the movie itself")
+	var duplicateVersion int32
+	if input.DuplicateVersion != nil {
+		duplicateVersion = *input.DuplicateVersion
+		mergeValidatorPtr.Check(duplicateVersion > 0, "duplicate_version", "must be a positive integer")
+	}
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), mergeValidatorPtr)
+	if !mergeValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, mergeValidatorPtr.Errors)
+		return
+	}
+
+	// If-Match makes the merge conditional on the version of the movie the client last saw, read notes(6)
+	if appPtr.ifMatchFails(r, moviePtr) {
+		appPtr.preconditionFailedResponse(w, r)
+		return
+	}
+
+	merge, err := appPtr.dbModel.MovieModel.MergeMovies(moviePtr, input.DuplicateID, duplicateVersion, appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		// the duplicate doesn't exist, or one of the two movies was deleted meanwhile
+		case errors.Is(err, data.ErrRecordNotFound):
+			mergeValidatorPtr.AddError("duplicate_id", "must be the id of a movie that isn't in the trash")
+			appPtr.failedValidationResponse(w, r, mergeValidatorPtr.Errors)
+		// one of the two movies changed since the client (or, for the movie, this request) read it
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// read the movie again, its review stats now count the duplicate's reviews
+	moviePtr, err = appPtr.dbModel.MovieModel.GetMovie(moviePtr.ID)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie, err := presentMovie(*moviePtr, nil, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"movie": movie, "merged": merge}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
 /*********************************************************************************************************************/
 /*
 NOTES
@@ -461,4 +981,18 @@ and say something like “JSON items with null values will be ignored and will r
 
 5 - POINTERS ARE ENCODED IN JSON AS THE VALUES POINTED TO
 Pointers to a value are json encoded as the value that the pointer points to.
+
+6 - ETAGS AND CONDITIONAL REQUESTS
+A movie's version is the number UpdateMovie uses for optimistic locking, it changes exactly when the movie's title,
+year, runtime, genres, status or release dates change. The responses to edits carry it in quotes e.g. "3" as their ETag. A client
+sends an ETag back in If-Match on PATCH, PUT and DELETE to say "only if nobody has changed it since I read it" (412 if
+they have). Without If-Match an edit still can't overwrite a newer version, it just gets the older 409 edit conflict.
+GET /v1/movies/:id shows much that isn't versioned though: the title translated into the client's language, the
+review stats, credits, images and external ids all change without the version moving. Its ETag is the
+version followed by a digest of the response e.g. "3-9f86d081884c7d65" (movieRepresentationETag), so that a cache or
+an offline client sending it back in If-None-Match only gets a 304 when the very same response would be sent, in the
+same language, fields and runtime format. That means reading everything before answering a 304, what the 304 saves
+is sending the movie. If-Match only looks at the version part, an edit doesn't care which language the client read.
+The release endpoints check If-Match a second time under the movie's row lock (ifMatchVersion), the version may
+move between reading the movie and changing its release dates.
 */
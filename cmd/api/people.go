@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/*********************************************************************************************************************/
+//POST /v1/people
+//To create a new person (director, actor, writer...)
+func (appPtr *application) createPersonHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string `json:"name"`
+		BirthYear int32  `json:"birth_year"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	person := data.Person{
+		Name:      input.Name,
+		BirthYear: input.BirthYear,
+	}
+
+	personValidatorPtr := validator.New()
+	data.ValidatePerson(personValidatorPtr, &person)
+	if !personValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, personValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.PersonModel.InsertPerson(&person)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Location", fmt.Sprintf("/v1/people/%d", person.ID))
+
+	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"person": person}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//GET /v1/people/:id
+//To get info about a specific person
+func (appPtr *application) showPersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	personPtr, err := appPtr.dbModel.PersonModel.GetPerson(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"person": *personPtr}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PATCH /v1/people/:id
+//To update a specific person
+func (appPtr *application) updatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	//pointers so we can tell the fields the client didn't send apart, see notes(4) in movies.go
+	var input struct {
+		Name      *string `json:"name"`
+		BirthYear *int32  `json:"birth_year"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	personPtr, err := appPtr.dbModel.PersonModel.GetPerson(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Name != nil {
+		personPtr.Name = *input.Name
+	}
+	if input.BirthYear != nil {
+		personPtr.BirthYear = *input.BirthYear
+	}
+
+	personValidatorPtr := validator.New()
+	data.ValidatePerson(personValidatorPtr, personPtr)
+	if !personValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, personValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.PersonModel.UpdatePerson(personPtr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"person": *personPtr}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/people/:id
+//To delete a specific person and all their credits
+func (appPtr *application) deletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	err = appPtr.dbModel.PersonModel.DeletePerson(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "person successfully deleted"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//GET /v1/people
+//To search people by name: Also allows for sorting, and pagination
+func (appPtr *application) showAllPeopleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string
+		Filters data.Filters
+	}
+
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	input.Name = appPtr.readString(queryString, "name", "")
+	input.Filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
+	input.Filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
+	input.Filters.Sort = appPtr.readString(queryString, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+
+	data.ValidateFilters(queryValidatorPtr, input.Filters)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	personPtrs, metadata, err := appPtr.dbModel.PersonModel.GetAllPeople(input.Name, input.Filters)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "people": personPtrs}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//POST /v1/movies/:id/credits
+//To credit a person on a movie as a director, actor or writer
+func (appPtr *application) createCreditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PersonID  int64  `json:"person_id"`
+		Role      string `json:"role"`
+		Character string `json:"character"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	credit := data.Credit{
+		MovieID:   moviePtr.ID,
+		PersonID:  input.PersonID,
+		Role:      input.Role,
+		Character: input.Character,
+	}
+
+	creditValidatorPtr := validator.New()
+	data.ValidateCredit(creditValidatorPtr, &credit)
+	if !creditValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, creditValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.CreditModel.InsertCredit(&credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			creditValidatorPtr.AddError("person_id", "no matching person found")
+			appPtr.failedValidationResponse(w, r, creditValidatorPtr.Errors)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			creditValidatorPtr.AddError("credit", "this person already has this credit on the movie")
+			appPtr.failedValidationResponse(w, r, creditValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"credit": credit}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies/:id/credits/:credit_id
+//To remove a credit from a movie
+func (appPtr *application) deleteCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	creditID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("credit_id"), 10, 64)
+	if err != nil || creditID < 1 {
+		appPtr.badRequestResponse(w, r, errors.New("read credit_id: invalid credit_id parameter"))
+		return
+	}
+
+	err = appPtr.dbModel.CreditModel.DeleteCredit(movieID, creditID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
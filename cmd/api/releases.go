@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/*********************************************************************************************************************/
+//GET /v1/movies/:id/releases
+//To list the dates a movie comes out in each country, earliest first
+func (appPtr *application) listMovieReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	releasePtrs, err := appPtr.dbModel.MovieReleaseModel.GetAllForMovie(moviePtr.ID)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"releases": releasePtrs}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PUT /v1/movies/:id/releases/:region
+//To set the date a movie comes out in a country e.g. PUT /v1/movies/1/releases/GB {"date": "2025-03-14"}, replacing
+//the date it had there before. The movie's year follows its earliest release, every change bumps its version and
+//the new ETag comes back with the response. Read notes(13) in internal/data/movies.go
+func (appPtr *application) putMovieReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Date string `json:"date"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	// If-Match lets a client make the change conditional on the version it last saw, as for an update
+	if appPtr.ifMatchFails(r, moviePtr) {
+		appPtr.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// "gb" is taken for "GB", a bad region is reported like a bad date rather than as a 404, the client typed it
+	release := data.MovieRelease{
+		Region: strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("region")),
+		Date:   input.Date,
+	}
+	releaseValidatorPtr := validator.New()
+	if data.ValidateRelease(releaseValidatorPtr, &release); !releaseValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, releaseValidatorPtr.Errors)
+		return
+	}
+
+	created, err := appPtr.dbModel.MovieReleaseModel.SetRelease(moviePtr, &release, ifMatchVersion(r, moviePtr), appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		// the movie changed since we checked If-Match against it
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrReleaseYear):
+			releaseValidatorPtr.AddError("date", fmt.Sprintf(
+				"would put the movie's first release after %d, but the movie is released already",
+				data.LatestMovieYear(moviePtr.Status),
+			))
+			appPtr.failedValidationResponse(w, r, releaseValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d/releases/%s", moviePtr.ID, release.Region))
+	}
+
+	err = appPtr.writeJSON(w, status, envelope{"release": release, "year": moviePtr.Year}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies/:id/releases/:region
+//To remove the date a movie comes out in a country, the movie's year follows its earliest remaining release
+func (appPtr *application) deleteMovieReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	if appPtr.ifMatchFails(r, moviePtr) {
+		appPtr.preconditionFailedResponse(w, r)
+		return
+	}
+
+	region := strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("region"))
+	err := appPtr.dbModel.MovieReleaseModel.DeleteRelease(moviePtr, region, ifMatchVersion(r, moviePtr), appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrReleaseYear):
+			appPtr.failedValidationResponse(w, r, map[string]string{"region": fmt.Sprintf(
+				"can't be removed, the movie is released and its other releases are all after %d",
+				data.LatestMovieYear(moviePtr.Status),
+			)})
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(moviePtr))
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "release successfully deleted", "year": moviePtr.Year}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
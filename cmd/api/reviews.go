@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+)
+
+/*********************************************************************************************************************/
+//POST /v1/movies/:id/reviews
+//To rate and review a movie as the authenticated user
+func (appPtr *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Rating int32  `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	review := data.Review{
+		MovieID: moviePtr.ID,
+		UserID:  appPtr.contextGetUser(r).ID,
+		Rating:  input.Rating,
+		Body:    input.Body,
+	}
+
+	reviewValidatorPtr := validator.New()
+	data.ValidateReview(reviewValidatorPtr, &review)
+	if !reviewValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, reviewValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.ReviewModel.InsertReview(&review)
+	if err != nil {
+		switch {
+		// the movie was moved to the trash since we read it
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		case errors.Is(err, data.ErrDuplicateReview):
+			reviewValidatorPtr.AddError("review", "you have already reviewed this movie, edit your review instead")
+			appPtr.failedValidationResponse(w, r, reviewValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews", moviePtr.ID))
+
+	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//GET /v1/movies/:id/reviews
+//To list the reviews of a movie, paginated and sorted like the movie listing
+func (appPtr *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
+	filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
+	filters.Sort = appPtr.readString(queryString, "sort", "-created_at")
+	filters.SortSafeList = []string{"id", "rating", "created_at", "-id", "-rating", "-created_at"}
+
+	data.ValidateFilters(queryValidatorPtr, filters)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	reviewPtrs, metadata, err := appPtr.dbModel.ReviewModel.GetAllForMovie(moviePtr.ID, filters)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "reviews": reviewPtrs}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PATCH /v1/movies/:id/reviews
+//To edit the authenticated user's own review of a movie
+func (appPtr *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	//pointers so we can tell the fields the client didn't send apart, see notes(4) in movies.go
+	var input struct {
+		Rating *int32  `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	//a user can only ever get at their own review, since we look it up by their id
+	reviewPtr, err := appPtr.dbModel.ReviewModel.GetReview(id, appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Rating != nil {
+		reviewPtr.Rating = *input.Rating
+	}
+	if input.Body != nil {
+		reviewPtr.Body = *input.Body
+	}
+
+	reviewValidatorPtr := validator.New()
+	data.ValidateReview(reviewValidatorPtr, reviewPtr)
+	if !reviewValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, reviewValidatorPtr.Errors)
+		return
+	}
+
+	err = appPtr.dbModel.ReviewModel.UpdateReview(reviewPtr)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			appPtr.editConflictResponse(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"review": *reviewPtr}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies/:id/reviews
+//To delete the authenticated user's own review of a movie
+func (appPtr *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	err = appPtr.dbModel.ReviewModel.DeleteReview(id, appPtr.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
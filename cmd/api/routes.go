@@ -10,6 +10,7 @@ import (
 const (
 	MOVIE_READ  = "movies:read"
 	MOVIE_WRITE = "movies:write"
+	GENRE_WRITE = "genres:write"
 )
 
 /*********************************************************************************************************************/
@@ -38,11 +39,32 @@ func (appPtr *application) routes() http.Handler {
 	routerPtr.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
 	//POST /v1/movies
-	//To create a new movie
-	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies", appPtr.requirePermission(MOVIE_WRITE, appPtr.createMovieHandler))
+	//To create a new movie, a retry with the same Idempotency-Key header gets the first response back
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies", appPtr.requirePermission(MOVIE_WRITE, appPtr.idempotent(appPtr.createMovieHandler)))
+	//POST /v1/movies/import
+	//To create many movies at once from a CSV or NDJSON body, or update those whose external ids we know, read notes(2)
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id", fixedIDPaths(
+		map[string]http.HandlerFunc{
+			"import": appPtr.requirePermission(MOVIE_WRITE, appPtr.importMoviesHandler),
+		},
+		appPtr.methodNotAllowedHandler,
+	))
 	//GET /v1/movies/:id
 	//To get info about a specific movie
-	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id", appPtr.requirePermission(MOVIE_READ, appPtr.showMovieHandler))
+	//GET /v1/movies/trash
+	//To list the deleted movies, shares the route with /v1/movies/:id, read notes(2)
+	//GET /v1/movies/export
+	//To download every movie matching a search as NDJSON or CSV
+	//GET /v1/movies/lookup?source=imdb&id=tt0111161
+	//To find a movie by its IMDb or TMDB id
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id", fixedIDPaths(
+		map[string]http.HandlerFunc{
+			"trash":  appPtr.requirePermission(MOVIE_WRITE, appPtr.showTrashHandler),
+			"export": appPtr.requirePermission(MOVIE_READ, appPtr.exportMoviesHandler),
+			"lookup": appPtr.requirePermission(MOVIE_READ, appPtr.lookupMovieHandler),
+		},
+		appPtr.requirePermission(MOVIE_READ, appPtr.showMovieHandler),
+	))
 
 	//PATCH /v1/movies/:id
 	//To update a field in a specific movie
@@ -53,22 +75,159 @@ func (appPtr *application) routes() http.Handler {
 	routerPtr.HandlerFunc(http.MethodPut, "/v1/movies/:id", appPtr.requireActivatedUser(appPtr.replaceMovieHandler))
 
 	//DELETE /v1/movies/:id
-	//To delete a specific movie from the db
+	//To move a specific movie to the trash
 	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id", appPtr.requirePermission(MOVIE_WRITE, appPtr.deleteMovieHandler))
 
+	//POST /v1/movies/:id/restore
+	//To take a deleted movie back out of the trash
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", appPtr.requirePermission(MOVIE_WRITE, appPtr.restoreMovieHandler))
+
+	//POST /v1/movies/:id/merge
+	//To fold a duplicate movie into a specific movie, along with its reviews, credits and watchlist entries
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", appPtr.requirePermission(MOVIE_WRITE, appPtr.mergeMovieHandler))
+
 	//GET /v1/movies
 	//To Get all the movies from the db: Also allows for filtering, sorting, and pagination
 	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies", appPtr.requirePermission(MOVIE_READ, appPtr.showAllMoviesHandler))
 
+	//PATCH /v1/movies
+	//To update many movies at once in a single transaction
+	routerPtr.HandlerFunc(http.MethodPatch, "/v1/movies", appPtr.requirePermission(MOVIE_WRITE, appPtr.batchUpdateMoviesHandler))
+
+	//DELETE /v1/movies
+	//To move many movies to the trash at once in a single transaction
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies", appPtr.requirePermission(MOVIE_WRITE, appPtr.batchDeleteMoviesHandler))
+
+	//GET /v1/movies/:id/similar
+	//To list the movies most like a movie, for "more like this"
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", appPtr.requirePermission(MOVIE_READ, appPtr.showSimilarMoviesHandler))
+
+	//REVISION HISTORY
+	//GET /v1/movies/:id/versions
+	//To list every version of a movie, who made it and when
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/versions", appPtr.requirePermission(MOVIE_READ, appPtr.listMovieVersionsHandler))
+	//GET /v1/movies/:id/versions/:version
+	//To get a movie as it was at a given version
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/versions/:version", appPtr.requirePermission(MOVIE_READ, appPtr.showMovieVersionHandler))
+	//POST /v1/movies/:id/revert?version=N
+	//To put a movie back the way it was at an earlier version
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert", appPtr.requirePermission(MOVIE_WRITE, appPtr.revertMovieHandler))
+
+	//IMAGES
+	//GET /v1/movies/:id/images
+	//To list the posters and stills of a movie
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/images", appPtr.requirePermission(MOVIE_READ, appPtr.listMovieImagesHandler))
+	//POST /v1/movies/:id/images
+	//To upload a poster or a still for a movie
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id/images", appPtr.requirePermission(MOVIE_WRITE, appPtr.uploadMovieImageHandler))
+	//DELETE /v1/movies/:id/images/:image_id
+	//To remove an image from a movie
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id/images/:image_id", appPtr.requirePermission(MOVIE_WRITE, appPtr.deleteMovieImageHandler))
+	//GET /v1/images/*filepath
+	//To fetch the images themselves from the local blob store, no permission needed
+	routerPtr.Handler(http.MethodGet, "/v1/images/*filepath", appPtr.serveImagesHandler(appPtr.config.images.dir))
+
+	//TRANSLATIONS
+	//GET /v1/movies/:id/translations
+	//To list the titles of a movie in other languages
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations", appPtr.requirePermission(MOVIE_READ, appPtr.listMovieTranslationsHandler))
+	//PUT /v1/movies/:id/translations/:language
+	//To set the title of a movie in a language, :language is a BCP-47 tag e.g. fr or pt-BR
+	routerPtr.HandlerFunc(http.MethodPut, "/v1/movies/:id/translations/:language", appPtr.requirePermission(MOVIE_WRITE, appPtr.putMovieTranslationHandler))
+	//DELETE /v1/movies/:id/translations/:language
+	//To remove the title of a movie in a language
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id/translations/:language", appPtr.requirePermission(MOVIE_WRITE, appPtr.deleteMovieTranslationHandler))
+
+	//EXTERNAL IDS
+	//PUT /v1/movies/:id/external_ids/:source
+	//To set the id a movie has in another movie database, :source is imdb or tmdb
+	routerPtr.HandlerFunc(http.MethodPut, "/v1/movies/:id/external_ids/:source", appPtr.requirePermission(MOVIE_WRITE, appPtr.putMovieExternalIDHandler))
+	//DELETE /v1/movies/:id/external_ids/:source
+	//To remove the id a movie has in another movie database
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id/external_ids/:source", appPtr.requirePermission(MOVIE_WRITE, appPtr.deleteMovieExternalIDHandler))
+
+	//RELEASES
+	//GET /v1/movies/:id/releases
+	//To list the dates a movie comes out in each country, earliest first
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/releases", appPtr.requirePermission(MOVIE_READ, appPtr.listMovieReleasesHandler))
+	//PUT /v1/movies/:id/releases/:region
+	//To set the date a movie comes out in a country, :region is a two letter country code e.g. GB
+	routerPtr.HandlerFunc(http.MethodPut, "/v1/movies/:id/releases/:region", appPtr.requirePermission(MOVIE_WRITE, appPtr.putMovieReleaseHandler))
+	//DELETE /v1/movies/:id/releases/:region
+	//To remove the date a movie comes out in a country
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id/releases/:region", appPtr.requirePermission(MOVIE_WRITE, appPtr.deleteMovieReleaseHandler))
+
+	//REVIEWS
+	//GET /v1/movies/:id/reviews
+	//To list the reviews of a movie
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", appPtr.requirePermission(MOVIE_READ, appPtr.listReviewsHandler))
+	//POST /v1/movies/:id/reviews
+	//To rate and review a movie as the authenticated user
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", appPtr.requireActivatedUser(appPtr.createReviewHandler))
+	//PATCH /v1/movies/:id/reviews
+	//To edit the authenticated user's own review of a movie
+	routerPtr.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews", appPtr.requireActivatedUser(appPtr.updateReviewHandler))
+	//DELETE /v1/movies/:id/reviews
+	//To delete the authenticated user's own review of a movie
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews", appPtr.requireActivatedUser(appPtr.deleteReviewHandler))
+
+	//PEOPLE AND CREDITS
+	//GET /v1/people
+	//To search people by name
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/people", appPtr.requirePermission(MOVIE_READ, appPtr.showAllPeopleHandler))
+	//POST /v1/people
+	//To create a new person
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/people", appPtr.requirePermission(MOVIE_WRITE, appPtr.createPersonHandler))
+	//GET /v1/people/:id
+	//To get info about a specific person
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/people/:id", appPtr.requirePermission(MOVIE_READ, appPtr.showPersonHandler))
+	//PATCH /v1/people/:id
+	//To update a specific person
+	routerPtr.HandlerFunc(http.MethodPatch, "/v1/people/:id", appPtr.requirePermission(MOVIE_WRITE, appPtr.updatePersonHandler))
+	//DELETE /v1/people/:id
+	//To delete a specific person and their credits
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/people/:id", appPtr.requirePermission(MOVIE_WRITE, appPtr.deletePersonHandler))
+	//POST /v1/movies/:id/credits
+	//To credit a person on a movie as a director, actor or writer
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", appPtr.requirePermission(MOVIE_WRITE, appPtr.createCreditHandler))
+	//DELETE /v1/movies/:id/credits/:credit_id
+	//To remove a credit from a movie
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", appPtr.requirePermission(MOVIE_WRITE, appPtr.deleteCreditHandler))
+
+	//GENRES
+	//GET /v1/genres
+	//To list the genres movies can be tagged with
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/genres", appPtr.requirePermission(MOVIE_READ, appPtr.listGenresHandler))
+	//POST /v1/genres
+	//To add a new genre
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/genres", appPtr.requirePermission(GENRE_WRITE, appPtr.createGenreHandler))
+	//PATCH /v1/genres/:id
+	//To rename, retire or reinstate a genre
+	routerPtr.HandlerFunc(http.MethodPatch, "/v1/genres/:id", appPtr.requirePermission(GENRE_WRITE, appPtr.updateGenreHandler))
+
 	//USERS ENDPOINT
 	//POST /v1/users
-	//To register(create) a new user
-	routerPtr.HandlerFunc(http.MethodPost, "/v1/users", appPtr.registerUserHandler)
+	//To register(create) a new user, a retry with the same Idempotency-Key header gets the first response back
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/users", appPtr.idempotent(appPtr.registerUserHandler))
 
 	//PUT /v1/users/activated
 	//To activate a specific user
 	routerPtr.HandlerFunc(http.MethodPut, "/v1/users/activated", appPtr.activateUserHandler)
 
+	//WATCHLIST
+	//GET /v1/users/me/watchlist
+	//To list the authenticated user's watchlist
+	routerPtr.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", appPtr.requireAuthenticatedUser(appPtr.listWatchlistHandler))
+	//POST /v1/users/me/watchlist
+	//To add a movie to the authenticated user's watchlist
+	routerPtr.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", appPtr.requireAuthenticatedUser(appPtr.addWatchlistEntryHandler))
+	//PATCH /v1/users/me/watchlist/:id
+	//To mark a movie on the authenticated user's watchlist as watched or unwatched
+	routerPtr.HandlerFunc(http.MethodPatch, "/v1/users/me/watchlist/:id", appPtr.requireAuthenticatedUser(appPtr.updateWatchlistEntryHandler))
+	//DELETE /v1/users/me/watchlist/:id
+	//To remove a movie from the authenticated user's watchlist
+	routerPtr.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", appPtr.requireAuthenticatedUser(appPtr.removeWatchlistEntryHandler))
+
 	//TOKENS
 	//STANDALONE ACTIVATION ENDPOINT
 	//POST /v1/tokens/activation
@@ -87,9 +246,31 @@ func (appPtr *application) routes() http.Handler {
 	return appPtr.metrics(appPtr.recoverPanic(appPtr.enableCORS(appPtr.rateLimit(appPtr.authenticate(routerPtr)))))
 }
 
+/*********************************************************************************************************************/
+// FIXED PATHS IN PLACE OF AN ID
+// fixedIDPaths returns a handler for a route ending in :id which sends the requests whose id is one of the
+// fixed paths in handlers (e.g "trash") to that path's handler, and every other request to next. Read notes(2)
+func fixedIDPaths(handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := handlers[httprouter.ParamsFromContext(r.Context()).ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
 /*
 1. CORS MIDDLEWARE POSITIONING
 If we positioned it after our rate limiter, for example, any cross-origin requests that exceed the rate limit would not
 have the Access-Control-Allow-Origin header set. This means that they would be blocked by the client’s web browser due
 to the same-origin policy, rather than the client receiving a 429 Too Many Requests response like they should.
+
+2. FIXED PATHS NEXT TO :id
+httprouter won't let a fixed segment and a named parameter share the same position in a path, registering
+GET /v1/movies/trash next to GET /v1/movies/:id panics with "conflicts with existing children". Rather than moving
+such endpoints somewhere less natural, we register them as values of the :id parameter with fixedIDPaths. An id is
+always a positive integer so it can never clash with a fixed path, and each fixed path keeps its own middleware.
+Where a method has no route of its own for /v1/movies/:id (POST), next is methodNotAllowedHandler so that any other
+id gets the same 405 it got before the fixed path was added.
 */
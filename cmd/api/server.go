@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -16,6 +17,9 @@ func (appPtr *application) serve() error {
 
 	shutdownErrorCh := make(chan error)
 	defer close(shutdownErrorCh)
+	// closed on shutdown to stop the periodic purges, which purgesWg waits for. Read notes(3)
+	purgesDoneCh := make(chan struct{})
+	var purgesWg sync.WaitGroup
 	// SERVER SETUP
 	srvPtr := &http.Server{
 		Addr:         fmt.Sprintf(":%d", appPtr.config.port),
@@ -47,6 +51,8 @@ func (appPtr *application) serve() error {
 		// call the String() method on the signal to get the signal name and include it
 		// in the log entry attributes.
 		appPtr.logger.Info("shutting down server", "signal", s.String())
+		// no purge starts from now on
+		close(purgesDoneCh)
 
 		// Create a context with a 30-second timeout.
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -71,12 +77,25 @@ func (appPtr *application) serve() error {
 		// complete their tasks.
 		appPtr.logger.Info("completing background tasks", "addr", srvPtr.Addr)
 		appPtr.wg.Wait()
+		purgesWg.Wait()
 		//If we had previously sent a value on the shutdownErrCh as if there was
 		//a shutdown error, when we reach this code, this goroutine will block
 		//until the app terminates
 		shutdownErrorCh <- nil
 	}()
 
+	// Start purging movies that have been in the trash for too long
+	// and forgetting Idempotency-Keys past their TTL, until we shut down
+	purgesWg.Add(2)
+	go func() {
+		defer purgesWg.Done()
+		appPtr.purgeTrash(purgesDoneCh)
+	}()
+	go func() {
+		defer purgesWg.Done()
+		appPtr.purgeIdempotencyKeys(purgesDoneCh)
+	}()
+
 	// SERVER START THE HTTP SERVER
 	// log that we're starting the server at this port and in this environment
 	appPtr.logger.Info("starting server", "addr", srvPtr.Addr, "env", appPtr.config.env)
@@ -183,4 +202,11 @@ Make sure the program doesn't exit and waits instead for Shutdown to return.
 Shutdown does not attempt to close nor wait for hijacked connections such as WebSockets. The caller of Shutdown
 should separately notify such long-lived connections of shutdown and wait for them to close, if desired. See
 Server.RegisterOnShutdown for a way to register shutdown notification functions.
+
+3. STOPPING THE PURGES
+purgeTrash and purgeIdempotencyKeys tick for as long as the server runs, so they can't be in appPtr.wg: we wait on it
+before calling Shutdown and would wait forever. They get their own waitgroup instead, and a done channel we close as
+soon as the signal arrives. A purge that is running at that moment is finished (it deletes in one transaction and
+then removes the blobs, stopping halfway would leave blobs behind), the others return straight away, and we wait for
+them along with the background tasks before serve returns.
 */
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+/*********************************************************************************************************************/
+//GET /v1/movies/:id/translations
+//To list the titles of a movie in every language it has been translated into
+func (appPtr *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	translationPtrs, err := appPtr.dbModel.MovieTranslationModel.GetAllForMovie(moviePtr.ID)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"translations": translationPtrs}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PUT /v1/movies/:id/translations/:language
+//To set the title of a movie in a language e.g. PUT /v1/movies/1/translations/fr {"title": "La Guerre des étoiles"},
+//replacing the title already there if the movie has been translated into that language before
+func (appPtr *application) putMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title string `json:"title"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	moviePtr, ok := appPtr.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	translation := data.MovieTranslation{
+		MovieID:  moviePtr.ID,
+		Language: httprouter.ParamsFromContext(r.Context()).ByName("language"),
+		Title:    input.Title,
+	}
+
+	translationValidatorPtr := validator.New()
+	if data.ValidateTranslation(translationValidatorPtr, &translation); !translationValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, translationValidatorPtr.Errors)
+		return
+	}
+	// "pt-br" and "pt-BR" are the same language, read notes(1) in internal/data/translations.go
+	translation.Language, _ = data.CanonicalLanguageTag(translation.Language)
+
+	created, err := appPtr.dbModel.MovieTranslationModel.UpsertTranslation(&translation)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	headers := http.Header{}
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d/translations/%s", moviePtr.ID, translation.Language))
+	}
+
+	err = appPtr.writeJSON(w, status, envelope{"translation": translation}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/movies/:id/translations/:language
+//To remove the title of a movie in a language, clients reading in that language go back to the original title
+func (appPtr *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	language, ok := data.CanonicalLanguageTag(httprouter.ParamsFromContext(r.Context()).ByName("language"))
+	if !ok {
+		appPtr.notFoundHandler(w, r)
+		return
+	}
+
+	err = appPtr.dbModel.MovieTranslationModel.DeleteTranslation(movieID, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+// LOCALIZE MOVIE
+// localizeMovie shows a movie under its title in the best of the client's languages, keeping the title it was
+// released under in OriginalTitle. A movie that hasn't been translated into any of them keeps its title.
+func (appPtr *application) localizeMovie(moviePtr *data.Movie, languages []string) error {
+	if len(languages) == 0 {
+		return nil
+	}
+	translationPtrs, err := appPtr.dbModel.MovieTranslationModel.GetAllForMovie(moviePtr.ID)
+	if err != nil {
+		return err
+	}
+	if translationPtr := data.BestTranslation(translationPtrs, languages); translationPtr != nil {
+		moviePtr.OriginalTitle = moviePtr.Title
+		moviePtr.Title = translationPtr.Title
+		moviePtr.Language = translationPtr.Language
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/data"
+	"greenlight-movie-api/internal/validator"
+	"net/http"
+)
+
+/*********************************************************************************************************************/
+//GET /v1/users/me/watchlist
+//To list the authenticated user's watchlist. Also allows for filtering by ?watched=true|false, sorting, and pagination
+func (appPtr *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Watched *bool
+		Filters data.Filters
+	}
+
+	queryString := r.URL.Query()
+	queryValidatorPtr := validator.New()
+
+	input.Watched = appPtr.readBool(queryString, "watched", queryValidatorPtr)
+	input.Filters.Page = appPtr.readInt(queryString, "page", 1, queryValidatorPtr)
+	input.Filters.PageSize = appPtr.readInt(queryString, "page_size", 20, queryValidatorPtr)
+	input.Filters.Sort = appPtr.readString(queryString, "sort", "-added_at")
+	input.Filters.SortSafeList = []string{"added_at", "title", "year", "runtime", "-added_at", "-title", "-year", "-runtime"}
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of every movie is written
+	runtimeFormat := appPtr.readRuntimeFormat(queryString, queryValidatorPtr)
+
+	data.ValidateFilters(queryValidatorPtr, input.Filters)
+	if !queryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, queryValidatorPtr.Errors)
+		return
+	}
+
+	//contextGetUser is the only place the user id comes from, so a user only ever sees their own list
+	entryPtrs, metadata, err := appPtr.dbModel.WatchlistModel.GetAllForUser(
+		appPtr.contextGetUser(r).ID, input.Watched, input.Filters,
+	)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	entries := make([]any, len(entryPtrs))
+	for i, entryPtr := range entryPtrs {
+		entries[i], err = presentWatchlistEntry(*entryPtr, runtimeFormat)
+		if err != nil {
+			appPtr.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "watchlist": entries}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//POST /v1/users/me/watchlist
+//To add a movie to the authenticated user's watchlist
+func (appPtr *application) addWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	entryValidatorPtr := validator.New()
+	entryValidatorPtr.Check(input.MovieID > 0, "movie_id", "must be provided")
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), entryValidatorPtr)
+	if !entryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, entryValidatorPtr.Errors)
+		return
+	}
+
+	entryPtr, err := appPtr.dbModel.WatchlistModel.AddEntry(appPtr.contextGetUser(r).ID, input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			entryValidatorPtr.AddError("movie_id", "no matching movie found")
+			appPtr.failedValidationResponse(w, r, entryValidatorPtr.Errors)
+		case errors.Is(err, data.ErrDuplicateWatchlistEntry):
+			entryValidatorPtr.AddError("movie_id", "movie is already on your watchlist")
+			appPtr.failedValidationResponse(w, r, entryValidatorPtr.Errors)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	entry, err := presentWatchlistEntry(*entryPtr, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/watchlist/%d", input.MovieID))
+
+	err = appPtr.writeJSON(w, http.StatusCreated, envelope{"watchlist_entry": entry}, headers)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//PATCH /v1/users/me/watchlist/:id
+//To mark a movie on the authenticated user's watchlist as watched or unwatched
+func (appPtr *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Watched *bool `json:"watched"`
+	}
+
+	err := appPtr.readJSON(w, r, &input)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, err)
+		return
+	}
+
+	//the :id in the url is the id of the movie on the watchlist
+	movieID, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	entryValidatorPtr := validator.New()
+	entryValidatorPtr.Check(input.Watched != nil, "watched", "must be provided")
+	// ?runtime_format=minutes|human|iso8601 picks how the runtime of the movie we send back is written
+	runtimeFormat := appPtr.readRuntimeFormat(r.URL.Query(), entryValidatorPtr)
+	if !entryValidatorPtr.Valid() {
+		appPtr.failedValidationResponse(w, r, entryValidatorPtr.Errors)
+		return
+	}
+
+	entryPtr, err := appPtr.dbModel.WatchlistModel.SetWatched(appPtr.contextGetUser(r).ID, movieID, *input.Watched)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	entry, err := presentWatchlistEntry(*entryPtr, runtimeFormat)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"watchlist_entry": entry}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
+
+/*********************************************************************************************************************/
+//DELETE /v1/users/me/watchlist/:id
+//To remove a movie from the authenticated user's watchlist
+func (appPtr *application) removeWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := appPtr.readIDParam(r)
+	if err != nil {
+		appPtr.badRequestResponse(w, r, fmt.Errorf("read id: %w", err))
+		return
+	}
+
+	err = appPtr.dbModel.WatchlistModel.RemoveEntry(appPtr.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			appPtr.notFoundHandler(w, r)
+		default:
+			appPtr.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = appPtr.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		appPtr.serverErrorResponse(w, r, err)
+	}
+}
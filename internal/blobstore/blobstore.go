@@ -0,0 +1,105 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*********************************************************************************************************************/
+/*
+STORE
+A Store keeps the files (blobs) we don't want in the database, such as movie posters, under a key like
+"movies/12/2f6c9e.jpg". Handlers only ever talk to this interface, so the local filesystem store below can be swapped
+for an object store (S3 and the like) without touching them.
+*/
+type Store interface {
+	// Put stores everything read from r under key, replacing any blob already there
+	Put(key string, r io.Reader) error
+	// Delete removes the blob under key, deleting a blob that doesn't exist is not an error
+	Delete(key string) error
+	// URL returns the address clients can fetch the blob under key from
+	URL(key string) string
+}
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+/*********************************************************************************************************************/
+/*
+LOCAL STORE
+Keeps blobs as files under a root directory on the server's own disk, with the key as their path relative to root.
+The files are served by the application itself from baseURL, see routes.go.
+*/
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+func NewLocal(root, baseURL string) (LocalStore, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return LocalStore{}, err
+	}
+	return LocalStore{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Root is the directory the blobs are kept in
+func (localStore LocalStore) Root() string {
+	return localStore.root
+}
+
+func (localStore LocalStore) Put(key string, r io.Reader) error {
+	filePath, err := localStore.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and rename it into place once it is complete, so a
+	// client can never fetch a half written blob
+	tmpFilePtr, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFilePtr.Name())
+
+	_, err = io.Copy(tmpFilePtr, r)
+	if closeErr := tmpFilePtr.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePtr.Name(), filePath)
+}
+
+func (localStore LocalStore) Delete(key string) error {
+	filePath, err := localStore.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (localStore LocalStore) URL(key string) string {
+	return localStore.baseURL + "/" + key
+}
+
+// path turns a key into a path under root, refusing keys that would escape it e.g. "../../etc/passwd"
+func (localStore LocalStore) path(key string) (string, error) {
+	if key == "" || !fs.ValidPath(key) || path.Clean(key) != key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(localStore.root, filepath.FromSlash(key)), nil
+}
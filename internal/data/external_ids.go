@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/validator"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrDuplicateExternalID error for when an external id is given to a movie while another movie
+// already has it.
+var (
+	ErrDuplicateExternalID = errors.New("duplicate external id")
+)
+
+/*********************************************************************************************************************/
+/*
+EXTERNAL IDS
+The ids other movie databases know a movie by, keyed by source e.g. {"imdb": "tt0111161", "tmdb": "278"}. An IMDb id
+is "tt" followed by 7 or more digits and a TMDB id is a positive number. ExternalIDSources holds the sources we know.
+*/
+var ExternalIDSources = []string{"imdb", "tmdb"}
+
+var externalIDFormats = map[string]struct {
+	rx      *regexp.Regexp
+	message string
+}{
+	"imdb": {regexp.MustCompile(`^tt[0-9]{7,10}$`), "must be an IMDb id e.g. tt0111161"},
+	"tmdb": {regexp.MustCompile(`^[1-9][0-9]{0,9}$`), "must be a TMDB id e.g. 278"},
+}
+
+/*********************************************************************************************************************/
+/*
+MOVIE EXTERNAL ID MODEL
+*/
+type MovieExternalIDModel struct {
+	DBPtr *sql.DB
+}
+
+/*
+SET EXTERNAL ID - Give a movie its id in a source, replacing the id it had there before if it had one. Reports whether
+the movie had no id in that source yet, and returns ErrDuplicateExternalID if another movie already has the id.
+*/
+func (externalIDModel MovieExternalIDModel) SetExternalID(movieID int64, source, externalID string) (bool, error) {
+	// xmax is only 0 on a row this statement inserted, read UpsertTranslation
+	query := `
+		INSERT INTO movie_external_ids (movie_id, source, external_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, source) DO UPDATE
+		SET external_id = EXCLUDED.external_id
+		RETURNING (xmax = 0)
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var created bool
+	err := externalIDModel.DBPtr.QueryRowContext(ctx, query, movieID, source, externalID).Scan(&created)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_external_ids_pkey"`:
+			return false, ErrDuplicateExternalID
+		default:
+			return false, err
+		}
+	}
+	return created, nil
+}
+
+/*
+GET ALL EXTERNAL IDS FOR A MOVIE - keyed by source
+*/
+func (externalIDModel MovieExternalIDModel) GetAllForMovie(movieID int64) (map[string]string, error) {
+	query := `
+		SELECT source, external_id
+		FROM movie_external_ids
+		WHERE movie_id = $1
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	externalIDRows, err := externalIDModel.DBPtr.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer externalIDRows.Close()
+
+	externalIDs := map[string]string{}
+	for externalIDRows.Next() {
+		var source, externalID string
+		if err := externalIDRows.Scan(&source, &externalID); err != nil {
+			return nil, err
+		}
+		externalIDs[source] = externalID
+	}
+	if err := externalIDRows.Err(); err != nil {
+		return nil, err
+	}
+	return externalIDs, nil
+}
+
+/*
+FIND MOVIE IDS - The ids of the movies that have any of the given external ids, movies in the trash included. There
+is more than one when the external ids are spread over several movies.
+*/
+func (externalIDModel MovieExternalIDModel) FindMovieIDs(externalIDs map[string]string) ([]int64, error) {
+	sources, ids := []string{}, []string{}
+	for source, externalID := range externalIDs {
+		sources, ids = append(sources, source), append(ids, externalID)
+	}
+	query := `
+		SELECT DISTINCT movie_id
+		FROM movie_external_ids
+		WHERE (source, external_id) IN (SELECT * FROM unnest($1::text[], $2::text[]))
+		ORDER BY movie_id
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	movieIDRows, err := externalIDModel.DBPtr.QueryContext(ctx, query, pq.Array(sources), pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer movieIDRows.Close()
+
+	movieIDs := []int64{}
+	for movieIDRows.Next() {
+		var movieID int64
+		if err := movieIDRows.Scan(&movieID); err != nil {
+			return nil, err
+		}
+		movieIDs = append(movieIDs, movieID)
+	}
+	if err := movieIDRows.Err(); err != nil {
+		return nil, err
+	}
+	return movieIDs, nil
+}
+
+/*
+DELETE EXTERNAL ID - Remove the id a movie has in a source
+*/
+func (externalIDModel MovieExternalIDModel) DeleteExternalID(movieID int64, source string) error {
+	query := `
+		DELETE FROM movie_external_ids
+		WHERE movie_id = $1 AND source = $2
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	result, err := externalIDModel.DBPtr.ExecContext(ctx, query, movieID, source)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*********************************************************************************************************************/
+/*
+VALIDATE EXTERNAL IDS
+Every source must be one we know and every id must be in that source's format. The errors are keyed by source.
+*/
+func ValidateExternalIDs(externalIDValidatorPtr *validator.Validator, externalIDs map[string]string) {
+	for source, externalID := range externalIDs {
+		format, known := externalIDFormats[source]
+		if !known {
+			externalIDValidatorPtr.AddError(source, fmt.Sprintf("is not a known source, expected one of %s", strings.Join(ExternalIDSources, ", ")))
+			continue
+		}
+		externalIDValidatorPtr.Check(validator.Matches(externalID, format.rx), source, format.message)
+	}
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES:
+1 - ONE MOVIE PER EXTERNAL ID
+The primary key of movie_external_ids is (source, external_id) so an IMDb id can only ever point at one movie, which
+is what lets GET /v1/movies/lookup and the import find "the" movie for an id. The unique (movie_id, source) means a
+movie has at most one id per source, setting another one replaces it. The same IMDb and TMDB numbers can of course
+appear in both sources, they are different ids. A movie in the trash keeps its ids until it is purged, so they can't
+be given to another movie meanwhile; restoring the movie brings them back with it.
+*/
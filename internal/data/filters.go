@@ -1,24 +1,52 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"greenlight-movie-api/internal/validator"
 	"math"
+	"slices"
+	"strconv"
+	"strings"
 )
 
+// Define an error that DecodeCursor() can return if the cursor sent by the client
+// could not be decoded back into a Cursor.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       string //opaque keyset cursor, when set we page with it instead of page/offset
+	//inclusive ranges keyed by the query parameter prefix e.g. "year" for year_min/year_max
+	Ranges map[string]Range
+	//the only fields the client wants in each record, all of them when empty
+	Fields        []string
+	FieldSafeList []string
+}
+
+// A Range is an inclusive min/max bound on a numeric column. A zero Min or Max means that
+// side of the range is unbounded, which works for us since none of the columns we range
+// over can meaningfully be 0.
+type Range struct {
+	Min int
+	Max int
 }
 
 type PageMetadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	Cursor       string `json:"cursor,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	//titles like the one searched for, when the search found nothing
+	DidYouMean []string `json:"did_you_mean,omitempty"`
 }
 
 func CalculatePageMetadata(totalRecords, pageSize, currentPage int) PageMetadata {
@@ -35,6 +63,16 @@ func CalculatePageMetadata(totalRecords, pageSize, currentPage int) PageMetadata
 	}
 }
 
+// In cursor mode we never count the matching rows (that is the expensive part we are
+// trying to avoid), so all we can report is the page size and the cursors.
+func CalculateCursorMetadata(pageSize int, cursor, nextCursor string) PageMetadata {
+	return PageMetadata{
+		PageSize:   pageSize,
+		Cursor:     cursor,
+		NextCursor: nextCursor,
+	}
+}
+
 // var allowedSortValues = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
 func ValidateFilters(filterValidatorPtr *validator.Validator, filters Filters) {
@@ -58,6 +96,56 @@ func ValidateFilters(filterValidatorPtr *validator.Validator, filters Filters) {
 		"sort",
 		fmt.Sprintf("must be a member of the following array: %+v", filters.SortSafeList),
 	)
+
+	//range bounds can't be negative and the lower bound can't be greater than the upper bound
+	//e.g. year_min=2000&year_max=1990 would never match anything
+	for key, rng := range filters.Ranges {
+		filterValidatorPtr.Check(rng.Min >= 0, key+"_min", "cannot be negative")
+		filterValidatorPtr.Check(rng.Max >= 0, key+"_max", "cannot be negative")
+		filterValidatorPtr.Check(
+			rng.Max == 0 || rng.Min <= rng.Max,
+			key+"_max",
+			fmt.Sprintf("must be greater than or equal to %s_min", key),
+		)
+	}
+
+	//a cursor is only valid for the sort it was issued for, and it replaces the page
+	//parameter entirely, so the two cannot be combined.
+	if filters.Cursor != "" {
+		cursorPtr, err := DecodeCursor(filters.Cursor)
+		filterValidatorPtr.Check(err == nil, "cursor", "is invalid")
+		if err == nil {
+			filterValidatorPtr.Check(
+				cursorPtr.Sort == filters.Sort,
+				"cursor",
+				"does not match the sort parameter it was issued for",
+			)
+			filterValidatorPtr.Check(cursorPtr.validValue(filters.sortColumn()), "cursor", "is invalid")
+		}
+		filterValidatorPtr.Check(filters.Page == 1, "page", "cannot be combined with cursor")
+	}
+
+	ValidateFields(filterValidatorPtr, filters.Fields, filters.FieldSafeList)
+}
+
+// ValidateFields checks a sparse fieldset (?fields=id,title) against the fields a client is
+// allowed to ask for. It is separate from ValidateFilters for the endpoints that return a
+// single record and so have no other filters.
+func ValidateFields(filterValidatorPtr *validator.Validator, fields []string, fieldSafeList []string) {
+	for _, field := range fields {
+		filterValidatorPtr.Check(
+			validator.PermittedValue(field, fieldSafeList...),
+			"fields",
+			fmt.Sprintf("must only contain members of the following array: %+v", fieldSafeList),
+		)
+	}
+	filterValidatorPtr.Check(validator.Unique(fields), "fields", "must not contain duplicate fields")
+}
+
+// wantsField reports whether the client asked for a field, which it has if it didn't
+// ask for any fields in particular
+func (filter Filters) wantsField(field string) bool {
+	return len(filter.Fields) == 0 || slices.Contains(filter.Fields, field)
 }
 
 func (filter Filters) offset() int {
@@ -71,3 +159,134 @@ func (filter Filters) limit() int {
 	//offset = 10
 	return filter.PageSize
 }
+
+// rangeConditions returns the WHERE predicates for the ranges on the given columns,
+// appending their bounds to args. Only columns the caller names are ever interpolated
+// into the query, never the keys a client could influence.
+func (filter Filters) rangeConditions(args *[]any, columns ...string) []string {
+	conditions := []string{}
+	for _, column := range columns {
+		rng := filter.Ranges[column]
+		if rng.Min > 0 {
+			*args = append(*args, rng.Min)
+			conditions = append(conditions, fmt.Sprintf("%s >= $%d", column, len(*args)))
+		}
+		if rng.Max > 0 {
+			*args = append(*args, rng.Max)
+			conditions = append(conditions, fmt.Sprintf("%s <= $%d", column, len(*args)))
+		}
+	}
+	return conditions
+}
+
+// sortColumn returns the sort value without its direction prefix e.g. "-year" -> "year"
+func (filter Filters) sortColumn() string {
+	return strings.TrimPrefix(filter.Sort, "-")
+}
+
+// sortDescending reports whether the client asked for a descending sort e.g. "-year"
+func (filter Filters) sortDescending() bool {
+	return strings.HasPrefix(filter.Sort, "-")
+}
+
+// orderBy returns the ORDER BY clause for the given sort expression. We always add
+// id as a tie-breaker so that rows sharing the same sort value come back in a stable
+// order, this is also what makes keyset pagination possible. See notes(1)
+func (filter Filters) orderBy(sortExpression string) string {
+	direction := "ASC"
+	if filter.sortDescending() {
+		direction = "DESC"
+	}
+	if filter.sortColumn() == "id" {
+		return fmt.Sprintf("id %s", direction)
+	}
+	return fmt.Sprintf("%s %s, id ASC", sortExpression, direction)
+}
+
+// keysetCondition returns the WHERE predicate which selects the rows that come after
+// the cursor for the given sort expression. valueParam and idParam are the positions
+// of the cursor's sort value and id in the query's argument list.
+func (filter Filters) keysetCondition(sortExpression string, valueParam, idParam int) string {
+	operator := ">"
+	if filter.sortDescending() {
+		operator = "<"
+	}
+	if filter.sortColumn() == "id" {
+		return fmt.Sprintf("id %s $%d", operator, idParam)
+	}
+	return fmt.Sprintf(
+		"(%s %s $%d OR (%s = $%d AND id > $%d))",
+		sortExpression, operator, valueParam, sortExpression, valueParam, idParam,
+	)
+}
+
+/*********************************************************************************************************************/
+/*
+CURSOR
+A cursor marks the last row a client has seen. It records the sort it was issued for, that row's value for the
+sort column and that row's id. It is handed to clients as an opaque base64 string so that they don't come to
+depend on its contents.
+*/
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"id"`
+}
+
+// Encode the cursor into the opaque string form we send to clients
+func (cursor Cursor) Encode() string {
+	//marshalling a struct of strings and an int64 cannot fail
+	jsonForm, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(jsonForm)
+}
+
+// DecodeCursor turns the opaque string sent by a client back into a Cursor, returning
+// ErrInvalidCursor if the string was tampered with or was never a cursor.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	jsonForm, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(jsonForm, &cursor); err != nil || cursor.ID < 1 || cursor.Sort == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// validValue reports whether the cursor's sort value is one the sort column could have had, in the form sortValue
+// writes it. Postgres casts the value to the column's type when we compare against it, a value crafted by a client
+// that doesn't cast (e.g. "abc" for a year) would otherwise fail the query rather than be refused.
+func (cursor Cursor) validValue(column string) bool {
+	switch column {
+	case "id":
+		return true
+	case "title":
+		return cursor.Value != ""
+	case "year", "runtime":
+		_, err := strconv.ParseInt(cursor.Value, 10, 32)
+		return err == nil
+	case "relevance", "rating":
+		// relevance is a real and rating a double precision, neither is ever NaN or infinite for a movie
+		bitSize := 64
+		if column == "relevance" {
+			bitSize = 32
+		}
+		value, err := strconv.ParseFloat(cursor.Value, bitSize)
+		return err == nil && !math.IsNaN(value) && !math.IsInf(value, 0)
+	default:
+		return false
+	}
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES:
+1 - KEYSET (CURSOR) PAGINATION
+With OFFSET, postgres still has to walk (and throw away) every row before the offset, so deep pages get slower the
+further in you go, and any row inserted or deleted before the offset shifts every later page by one. With keyset
+pagination we instead remember the sort value and id of the last row on the page and ask for the rows "after" it:
+    ORDER BY year DESC, id ASC  ->  WHERE (year < $v OR (year = $v AND id > $id))
+The id tie-breaker is what makes "after" well defined when many rows share the same year.
+*/
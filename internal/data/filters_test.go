@@ -0,0 +1,130 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"greenlight-movie-api/internal/validator"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    *Cursor
+	}{
+		{name: "round trip", encoded: Cursor{Sort: "-year", Value: "1999", ID: 42}.Encode(), want: &Cursor{Sort: "-year", Value: "1999", ID: 42}},
+		{name: "id sort has no value", encoded: Cursor{Sort: "id", ID: 7}.Encode(), want: &Cursor{Sort: "id", ID: 7}},
+		{name: "not base64", encoded: "not a cursor!"},
+		{name: "not json", encoded: base64.RawURLEncoding.EncodeToString([]byte("year:1999"))},
+		{name: "id of 0", encoded: Cursor{Sort: "year", Value: "1999"}.Encode()},
+		{name: "negative id", encoded: Cursor{Sort: "year", Value: "1999", ID: -3}.Encode()},
+		{name: "no sort", encoded: Cursor{Value: "1999", ID: 1}.Encode()},
+		{name: "empty", encoded: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursorPtr, err := DecodeCursor(test.encoded)
+			if test.want == nil {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Fatalf("got %+v, %v; want ErrInvalidCursor", cursorPtr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *cursorPtr != *test.want {
+				t.Errorf("got %+v; want %+v", *cursorPtr, *test.want)
+			}
+		})
+	}
+}
+
+func TestCursorValidValue(t *testing.T) {
+	tests := []struct {
+		column string
+		value  string
+		want   bool
+	}{
+		{"id", "", true},
+		{"title", "Moana", true},
+		{"title", "", false},
+		{"year", "1999", true},
+		{"year", "-5", true},
+		{"year", "abc", false},
+		{"year", "19.5", false},
+		{"year", "", false},
+		{"runtime", "2147483647", true},
+		{"runtime", "2147483648", false},
+		{"rating", "7.5", true},
+		{"rating", "0", true},
+		{"rating", "NaN", false},
+		{"rating", "Inf", false},
+		{"rating", "seven", false},
+		{"relevance", "-0.0607927", true},
+		{"relevance", "1e300", false},
+		{"similarity", "0.5", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.column+"="+test.value, func(t *testing.T) {
+			if got := (Cursor{Sort: test.column, Value: test.value, ID: 1}).validValue(test.column); got != test.want {
+				t.Errorf("got %t; want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		sort           string
+		sortExpression string
+		want           string
+	}{
+		{"id", "id", "id > $3"},
+		{"-id", "id", "id < $3"},
+		{"year", "year", "(year > $2 OR (year = $2 AND id > $3))"},
+		{"-year", "year", "(year < $2 OR (year = $2 AND id > $3))"},
+		{"relevance", "-ts_rank(x)", "(-ts_rank(x) > $2 OR (-ts_rank(x) = $2 AND id > $3))"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sort, func(t *testing.T) {
+			got := Filters{Sort: test.sort}.keysetCondition(test.sortExpression, 2, 3)
+			if got != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+		want   string
+	}{
+		{name: "issued cursor", cursor: Cursor{Sort: "-year", Value: "1999", ID: 1}.Encode()},
+		{name: "crafted value", cursor: Cursor{Sort: "-year", Value: "abc", ID: 1}.Encode(), want: "is invalid"},
+		{name: "other sort", cursor: Cursor{Sort: "title", Value: "Moana", ID: 1}.Encode(), want: "does not match the sort parameter it was issued for"},
+		{name: "garbage", cursor: "%%%", want: "is invalid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filterValidatorPtr := validator.New()
+			ValidateFilters(filterValidatorPtr, Filters{
+				Page:         1,
+				PageSize:     20,
+				Sort:         "-year",
+				SortSafeList: []string{"year", "-year", "title"},
+				Cursor:       test.cursor,
+			})
+			if got := filterValidatorPtr.Errors["cursor"]; got != test.want {
+				t.Errorf("got cursor error %q; want %q", got, test.want)
+			}
+		})
+	}
+}
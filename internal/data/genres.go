@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/validator"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Define a custom ErrDuplicateGenre error for when a genre is added or renamed to a name
+// which is already taken (violation of the genres_name_key constraint).
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+)
+
+// Genre names are lowercase words which may be joined by hyphens e.g. "drama" or "sci-fi"
+var GenreRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// How long we keep serving the cached list of active genres before reading it from the
+// database again. Changes made through this instance clear the cache straight away, this
+// only bounds how long other instances of the API can take to see them.
+const genreCacheTTL = time.Minute
+
+/*********************************************************************************************************************/
+// GENRE STRUCT
+// A genre movies can be tagged with. Retired genres stay on the movies that already have them, but can't be
+// used for new ones.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Retired   bool      `json:"retired"`
+	Version   int32     `json:"version"`
+}
+
+// GENRE MODEL
+// Create a GenreModel struct which wraps the connection pool. It also holds a pointer to the cache of active genre
+// names, so that every copy of the model (the methods use value receivers) shares the same cache.
+type GenreModel struct {
+	DBPtr    *sql.DB
+	cachePtr *genreCache
+}
+
+type genreCache struct {
+	mut      sync.RWMutex
+	names    []string
+	loadedAt time.Time
+}
+
+/*********************************************************************************************************************/
+/*
+VALIDATE GENRE
+*/
+func ValidateGenre(genreValidatorPtr *validator.Validator, genrePtr *Genre) {
+	genreValidatorPtr.Check(genrePtr.Name != "", "name", "cannot be empty")
+	genreValidatorPtr.Check(len(genrePtr.Name) <= 50, "name", "cannot be more than 50 bytes long")
+	genreValidatorPtr.Check(
+		validator.Matches(genrePtr.Name, GenreRX),
+		"name",
+		"must be lowercase letters and digits, optionally joined by hyphens e.g. sci-fi",
+	)
+}
+
+/*********************************************************************************************************************/
+/*
+ACTIVE GENRE NAMES
+Return the names of the genres that are not retired. This is called to validate every movie that is written and
+every genres filter on the movie listing, so we serve it from an in-memory cache which we refresh every
+genreCacheTTL, or as soon as a genre is added, renamed or retired through this model.
+*/
+func (genreModel GenreModel) ActiveGenreNames() ([]string, error) {
+	genreModel.cachePtr.mut.RLock()
+	if genreModel.cachePtr.names != nil && time.Since(genreModel.cachePtr.loadedAt) < genreCacheTTL {
+		names := genreModel.cachePtr.names
+		genreModel.cachePtr.mut.RUnlock()
+		return names, nil
+	}
+	genreModel.cachePtr.mut.RUnlock()
+
+	genrePtrs, err := genreModel.GetAllGenres(false)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(genrePtrs))
+	for _, genrePtr := range genrePtrs {
+		names = append(names, genrePtr.Name)
+	}
+
+	genreModel.cachePtr.mut.Lock()
+	genreModel.cachePtr.names = names
+	genreModel.cachePtr.loadedAt = time.Now()
+	genreModel.cachePtr.mut.Unlock()
+
+	return names, nil
+}
+
+// GenresForEdit returns the genres a movie that is being edited can be tagged with: the active genres, plus the
+// genres it already has, since retired genres stay on the movies that have them. Only adding a retired genre is
+// refused. currentGenres are the movie's genres before the edit.
+func GenresForEdit(activeGenres []string, currentGenres []string) []string {
+	// a new slice, activeGenres is the cached one
+	allowedGenres := make([]string, 0, len(activeGenres)+len(currentGenres))
+	allowedGenres = append(allowedGenres, activeGenres...)
+	for _, genre := range currentGenres {
+		if !validator.PermittedValue(genre, allowedGenres...) {
+			allowedGenres = append(allowedGenres, genre)
+		}
+	}
+	return allowedGenres
+}
+
+// clearCache forces the next call to ActiveGenreNames to read from the database
+func (genreModel GenreModel) clearCache() {
+	genreModel.cachePtr.mut.Lock()
+	genreModel.cachePtr.names = nil
+	genreModel.cachePtr.mut.Unlock()
+}
+
+/*********************************************************************************************************************/
+/*
+GENRE MODEL DB INTERACTIONS (CRUD)
+*/
+/*
+GET ALL GENRES - in alphabetical order, with or without the retired ones
+*/
+func (genreModel GenreModel) GetAllGenres(includeRetired bool) ([]*Genre, error) {
+	query := `
+		SELECT id, created_at, name, retired, version
+		FROM genres
+		WHERE (retired = false OR $1)
+		ORDER BY name
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	genreRows, err := genreModel.DBPtr.QueryContext(ctx, query, includeRetired)
+	if err != nil {
+		return nil, err
+	}
+	defer genreRows.Close()
+
+	genrePtrs := []*Genre{}
+	for genreRows.Next() {
+		var genre Genre
+		err := genreRows.Scan(&genre.ID, &genre.CreatedAt, &genre.Name, &genre.Retired, &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+		genrePtrs = append(genrePtrs, &genre)
+	}
+
+	if err := genreRows.Err(); err != nil {
+		return nil, err
+	}
+	return genrePtrs, nil
+}
+
+/*
+READ (GET) GENRE
+*/
+func (genreModel GenreModel) GetGenre(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, retired, version
+		FROM genres
+		WHERE id = $1
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var genre Genre
+	err := genreModel.DBPtr.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID, &genre.CreatedAt, &genre.Name, &genre.Retired, &genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &genre, nil
+}
+
+/*
+CREATE (INSERT) GENRE
+*/
+func (genreModel GenreModel) InsertGenre(genrePtr *Genre) error {
+	query := `
+		INSERT INTO genres(name)
+		VALUES($1) RETURNING id, created_at, retired, version
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	err := genreModel.DBPtr.QueryRowContext(ctx, query, genrePtr.Name).Scan(
+		&genrePtr.ID, &genrePtr.CreatedAt, &genrePtr.Retired, &genrePtr.Version,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_name_key"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	genreModel.clearCache()
+	return nil
+}
+
+/*
+UPDATE GENRE - Rename and/or retire a genre, using the usual optimistic concurrency check on the version. When the
+name changes we also rename the genre in the genres array of every movie tagged with it, in the same transaction,
+so that no movie is ever left with a genre that doesn't exist. Read notes(1). editedBy is the user renaming the
+genre, who the new versions of those movies are attributed to
+*/
+func (genreModel GenreModel) UpdateGenre(genrePtr *Genre, oldName string, editedBy int64) error {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+
+	txPtr, err := genreModel.DBPtr.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer txPtr.Rollback()
+
+	query := `
+		UPDATE genres
+		SET name = $1, retired = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version
+	`
+	err = txPtr.QueryRowContext(ctx, query, genrePtr.Name, genrePtr.Retired, genrePtr.ID, genrePtr.Version).Scan(
+		&genrePtr.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_name_key"`:
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+
+	if genrePtr.Name != oldName {
+		// the movies' version is bumped as well, their genres did change after all, and
+		// the new versions are recorded in the movies' history
+		query = fmt.Sprintf(`
+			WITH updated AS (
+				UPDATE movies
+				SET genres = array_replace(genres, $1, $2), version = version + 1
+				WHERE genres @> ARRAY[$1]
+				RETURNING id, version, title, year, runtime, genres, status
+			)
+			%s
+		`, snapshotMovies("updated", 3))
+		_, err = txPtr.ExecContext(ctx, query, oldName, genrePtr.Name, editedBy)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = txPtr.Commit()
+	if err != nil {
+		return err
+	}
+
+	genreModel.clearCache()
+	return nil
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES:
+1 - RENAMING GENRES
+Movies store their genres as a text[] of names rather than ids (that is what the movies_genres_idx GIN index and the
+genres filter on the movie listing are built around), so renaming "sci-fi" to "science-fiction" means rewriting every
+array that contains "sci-fi". array_replace() swaps the old name for the new one in place, and the genres @> ARRAY[$1]
+predicate lets postgres find those movies through movies_genres_idx instead of scanning the whole table.
+*/
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+/*********************************************************************************************************************/
+// IDEMPOTENCY RECORD STRUCT
+// What we remember about the first request sent with an Idempotency-Key. Scope keeps the keys of different endpoints
+// and users apart, Fingerprint identifies the request itself (see the idempotent middleware) and the Status, Headers
+// and Body are the response we replay to every retry. Status is 0 while the first request is still being handled.
+type IdempotencyRecord struct {
+	Scope       string
+	Key         string
+	Fingerprint []byte
+	Status      int
+	Headers     http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+/*********************************************************************************************************************/
+/*
+IDEMPOTENCY MODEL
+*/
+type IdempotencyModel struct {
+	DBPtr *sql.DB
+}
+
+/*
+RESERVE KEY - Claim a key for a request that is about to be handled, so that a retry arriving meanwhile knows it is
+in flight. Reports false if the key is already taken (and hasn't expired), in which case the caller should Get it.
+*/
+func (idempotencyModel IdempotencyModel) Reserve(recordPtr *IdempotencyRecord, ttl time.Duration) (bool, error) {
+	// An expired key is as good as a free one, it is taken over rather than waiting to be purged
+	query := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * interval '1 millisecond')
+		ON CONFLICT (scope, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = '{}', body = NULL,
+		created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+		RETURNING expires_at
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	err := idempotencyModel.DBPtr.QueryRowContext(
+		ctx, query, recordPtr.Scope, recordPtr.Key, recordPtr.Fingerprint, ttl.Milliseconds(),
+	).Scan(&recordPtr.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+/*
+GET KEY - Fetch what we know about a key that hasn't expired
+*/
+func (idempotencyModel IdempotencyModel) Get(scope, key string) (*IdempotencyRecord, error) {
+	query := `
+		SELECT scope, key, fingerprint, COALESCE(status, 0), headers, COALESCE(body, ''), expires_at
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2 AND expires_at > NOW()
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var record IdempotencyRecord
+	var headers []byte
+	err := idempotencyModel.DBPtr.QueryRowContext(ctx, query, scope, key).Scan(
+		&record.Scope, &record.Key, &record.Fingerprint, &record.Status, &headers, &record.Body, &record.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(headers, &record.Headers); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+/*
+COMPLETE KEY - Store the response to the request that reserved a key, for its retries to replay
+*/
+func (idempotencyModel IdempotencyModel) Complete(recordPtr *IdempotencyRecord) error {
+	headers, err := json.Marshal(recordPtr.Headers)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE idempotency_keys
+		SET status = $3, headers = $4, body = $5
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	_, err = idempotencyModel.DBPtr.ExecContext(
+		ctx, query, recordPtr.Scope, recordPtr.Key, recordPtr.Status, headers, recordPtr.Body,
+	)
+	return err
+}
+
+/*
+RELEASE KEY - Forget a key whose request failed on our side, so that a retry is handled afresh
+*/
+func (idempotencyModel IdempotencyModel) Release(scope, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	_, err := idempotencyModel.DBPtr.ExecContext(ctx, query, scope, key)
+	return err
+}
+
+/*
+DELETE EXPIRED KEYS - Delete every key past its TTL, returning how many there were
+*/
+func (idempotencyModel IdempotencyModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at <= NOW()
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	result, err := idempotencyModel.DBPtr.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The kinds of artwork a movie can have
+const (
+	ImagePoster = "poster"
+	ImageStill  = "still"
+)
+
+var ImageKinds = []string{ImagePoster, ImageStill}
+
+/*********************************************************************************************************************/
+// MOVIE IMAGE STRUCT
+// A poster or a still of a movie. The image itself and its thumbnail live in the blob store under BlobKey and
+// ThumbnailKey, the handlers fill in the URLs clients fetch them from.
+type MovieImage struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"-"`
+	MovieID      int64     `json:"movie_id"`
+	Kind         string    `json:"kind"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	BlobKey      string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+}
+
+/*********************************************************************************************************************/
+/*
+MOVIE IMAGE MODEL
+*/
+type MovieImageModel struct {
+	DBPtr *sql.DB
+}
+
+/*
+CREATE (INSERT) IMAGE - Record an image whose blobs have already been stored
+*/
+func (movieImageModel MovieImageModel) InsertImage(imagePtr *MovieImage) error {
+	query := `
+		INSERT INTO movie_images (movie_id, kind, content_type, width, height, blob_key, thumbnail_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	return movieImageModel.DBPtr.QueryRowContext(
+		ctx,
+		query,
+		imagePtr.MovieID, imagePtr.Kind, imagePtr.ContentType, imagePtr.Width, imagePtr.Height,
+		imagePtr.BlobKey, imagePtr.ThumbnailKey,
+	).Scan(&imagePtr.ID, &imagePtr.CreatedAt)
+}
+
+/*
+GET ALL IMAGES FOR A MOVIE - posters first, then stills, oldest first
+*/
+func (movieImageModel MovieImageModel) GetAllForMovie(movieID int64) ([]*MovieImage, error) {
+	query := `
+		SELECT id, created_at, movie_id, kind, content_type, width, height, blob_key, thumbnail_key
+		FROM movie_images
+		WHERE movie_id = $1
+		ORDER BY kind = 'poster' DESC, id ASC
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	imageRows, err := movieImageModel.DBPtr.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer imageRows.Close()
+
+	imagePtrs := []*MovieImage{}
+	for imageRows.Next() {
+		var image MovieImage
+		err := imageRows.Scan(
+			&image.ID, &image.CreatedAt, &image.MovieID, &image.Kind, &image.ContentType,
+			&image.Width, &image.Height, &image.BlobKey, &image.ThumbnailKey,
+		)
+		if err != nil {
+			return nil, err
+		}
+		imagePtrs = append(imagePtrs, &image)
+	}
+	if err := imageRows.Err(); err != nil {
+		return nil, err
+	}
+	return imagePtrs, nil
+}
+
+/*
+DELETE IMAGE - Remove an image of a movie, returning it so the caller can delete its blobs
+*/
+func (movieImageModel MovieImageModel) DeleteImage(movieID, imageID int64) (*MovieImage, error) {
+	if movieID < 1 || imageID < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		DELETE FROM movie_images
+		WHERE id = $1 AND movie_id = $2
+		RETURNING id, created_at, movie_id, kind, content_type, width, height, blob_key, thumbnail_key
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var image MovieImage
+	err := movieImageModel.DBPtr.QueryRowContext(ctx, query, imageID, movieID).Scan(
+		&image.ID, &image.CreatedAt, &image.MovieID, &image.Kind, &image.ContentType,
+		&image.Width, &image.Height, &image.BlobKey, &image.ThumbnailKey,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &image, nil
+}
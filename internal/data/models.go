@@ -25,10 +25,21 @@ We’re going to wrap our MovieModel in a parent Models struct.  it has the bene
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	MovieModel      MovieModel
-	UserModel       UserModel
-	TokenModel      TokenModel
-	PermissionModel PermissionModel
+	MovieModel            MovieModel
+	UserModel             UserModel
+	TokenModel            TokenModel
+	PermissionModel       PermissionModel
+	ReviewModel           ReviewModel
+	WatchlistModel        WatchlistModel
+	PersonModel           PersonModel
+	CreditModel           CreditModel
+	GenreModel            GenreModel
+	MovieVersionModel     MovieVersionModel
+	MovieImageModel       MovieImageModel
+	MovieTranslationModel MovieTranslationModel
+	IdempotencyModel      IdempotencyModel
+	MovieExternalIDModel  MovieExternalIDModel
+	MovieReleaseModel     MovieReleaseModel
 }
 
 /*
@@ -39,9 +50,20 @@ a "Models" object
 */
 func NewModel(dbPtr *sql.DB) Models {
 	return Models{
-		MovieModel:      MovieModel{DBPtr: dbPtr},
-		UserModel:       UserModel{DBPtr: dbPtr},
-		TokenModel:      TokenModel{DBPtr: dbPtr},
-		PermissionModel: PermissionModel{DBPtr: dbPtr},
+		MovieModel:            MovieModel{DBPtr: dbPtr},
+		UserModel:             UserModel{DBPtr: dbPtr},
+		TokenModel:            TokenModel{DBPtr: dbPtr},
+		PermissionModel:       PermissionModel{DBPtr: dbPtr},
+		ReviewModel:           ReviewModel{DBPtr: dbPtr},
+		WatchlistModel:        WatchlistModel{DBPtr: dbPtr},
+		PersonModel:           PersonModel{DBPtr: dbPtr},
+		CreditModel:           CreditModel{DBPtr: dbPtr},
+		GenreModel:            GenreModel{DBPtr: dbPtr, cachePtr: &genreCache{}},
+		MovieVersionModel:     MovieVersionModel{DBPtr: dbPtr},
+		MovieImageModel:       MovieImageModel{DBPtr: dbPtr},
+		MovieTranslationModel: MovieTranslationModel{DBPtr: dbPtr},
+		IdempotencyModel:      IdempotencyModel{DBPtr: dbPtr},
+		MovieExternalIDModel:  MovieExternalIDModel{DBPtr: dbPtr},
+		MovieReleaseModel:     MovieReleaseModel{DBPtr: dbPtr},
 	}
 }
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*********************************************************************************************************************/
+// MOVIE MERGE STRUCT
+// How much of a duplicate was carried over to the movie it was merged into, one count of rows per table. Rows the
+// movie already had an equivalent of (the same user's review, the same credit...) stay with the duplicate.
+type MovieMerge struct {
+	Reviews      int64 `json:"reviews"`
+	Credits      int64 `json:"credits"`
+	Watchlist    int64 `json:"watchlist"`
+	Images       int64 `json:"images"`
+	Translations int64 `json:"translations"`
+	ExternalIDs  int64 `json:"external_ids"`
+	Releases     int64 `json:"releases"`
+}
+
+// The statements that carry a duplicate's rows over to the movie it is merged into, in the order they run. $1 is the
+// movie and $2 the duplicate. The watchlist has two: a user with both movies on their watchlist has watched the movie
+// if they watched either, then the entries of users without the movie on their watchlist move across.
+var movieMergeStatements = []struct {
+	query string
+	count func(mergePtr *MovieMerge) *int64
+}{
+	{
+		query: `
+			UPDATE reviews SET movie_id = $1
+			WHERE movie_id = $2 AND user_id NOT IN (SELECT user_id FROM reviews WHERE movie_id = $1)
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.Reviews },
+	},
+	{
+		query: `
+			UPDATE credits SET movie_id = $1
+			WHERE movie_id = $2 AND NOT EXISTS (
+				SELECT 1 FROM credits AS kept
+				WHERE kept.movie_id = $1 AND kept.person_id = credits.person_id
+				AND kept.role = credits.role AND kept.character_name = credits.character_name
+			)
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.Credits },
+	},
+	{
+		query: `
+			UPDATE watchlist SET watched = true
+			FROM watchlist AS duplicate
+			WHERE watchlist.movie_id = $1 AND duplicate.movie_id = $2
+			AND duplicate.user_id = watchlist.user_id AND duplicate.watched AND NOT watchlist.watched
+		`,
+	},
+	{
+		query: `
+			UPDATE watchlist SET movie_id = $1
+			WHERE movie_id = $2 AND user_id NOT IN (SELECT user_id FROM watchlist WHERE movie_id = $1)
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.Watchlist },
+	},
+	{
+		query: `
+			UPDATE movie_images SET movie_id = $1
+			WHERE movie_id = $2
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.Images },
+	},
+	{
+		query: `
+			UPDATE movie_translations SET movie_id = $1
+			WHERE movie_id = $2 AND language NOT IN (SELECT language FROM movie_translations WHERE movie_id = $1)
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.Translations },
+	},
+	{
+		query: `
+			UPDATE movie_external_ids SET movie_id = $1
+			WHERE movie_id = $2 AND source NOT IN (SELECT source FROM movie_external_ids WHERE movie_id = $1)
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.ExternalIDs },
+	},
+	{
+		query: `
+			UPDATE movie_releases SET movie_id = $1
+			WHERE movie_id = $2 AND region NOT IN (SELECT region FROM movie_releases WHERE movie_id = $1)
+		`,
+		count: func(mergePtr *MovieMerge) *int64 { return &mergePtr.Releases },
+	},
+}
+
+/*
+MERGE MOVIES - Fold a duplicate into a movie in a single transaction on behalf of the user mergedBy: the duplicate's
+reviews, credits, watchlist entries, images, translations, external ids and release dates are carried over to the movie,
+whose year is derived from its release dates again, and the duplicate is moved to the trash. The movie gets a new
+version, its Year and Version are updated. Returns ErrRecordNotFound if either movie doesn't exist or is in the trash,
+and ErrEditConflict if the movie is no longer at its Version or the duplicate at duplicateVersion (0 skips that check).
+Read notes(12) in movies.go
+*/
+func (movieModel MovieModel) MergeMovies(moviePtr *Movie, duplicateID int64, duplicateVersion int32, mergedBy int64) (*MovieMerge, error) {
+	movieID := moviePtr.ID
+	if movieID < 1 || duplicateID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFunc()
+
+	txPtr, err := movieModel.DBPtr.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer txPtr.Rollback()
+
+	// Lock both movies (always in id order, so two merges of the same pair can't deadlock) so that neither can be
+	// edited, deleted or merged elsewhere while we move rows between them, and check they are still at the versions
+	// the client saw
+	versionRows, err := txPtr.QueryContext(ctx, `
+		SELECT id, version FROM movies
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, pq.Array([]int64{movieID, duplicateID}))
+	if err != nil {
+		return nil, err
+	}
+	versions := map[int64]int32{}
+	for versionRows.Next() {
+		var id int64
+		var version int32
+		if err := versionRows.Scan(&id, &version); err != nil {
+			versionRows.Close()
+			return nil, err
+		}
+		versions[id] = version
+	}
+	versionRows.Close()
+	if err := versionRows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) != 2 {
+		return nil, ErrRecordNotFound
+	}
+	if versions[movieID] != moviePtr.Version || (duplicateVersion != 0 && versions[duplicateID] != duplicateVersion) {
+		return nil, ErrEditConflict
+	}
+
+	var merge MovieMerge
+	for _, statement := range movieMergeStatements {
+		result, err := txPtr.ExecContext(ctx, statement.query, movieID, duplicateID)
+		if err != nil {
+			return nil, err
+		}
+		if statement.count == nil {
+			continue
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		*statement.count(&merge) = rowsAffected
+	}
+
+	// What is shown with the movie has changed, so it gets a new version (and ETag) whether or not its year changes,
+	// the duplicate's release dates may well be earlier than the movie's, read notes(13) in movies.go
+	err = txPtr.QueryRowContext(ctx, deriveYearQuery(true), movieID, mergedBy).Scan(&moviePtr.Year, &moviePtr.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// the duplicate goes to the trash like a deleted movie, with what it couldn't hand over
+	_, err = txPtr.ExecContext(ctx, `
+		UPDATE movies SET deleted_at = NOW(), deleted_by = $2
+		WHERE id = $1
+	`, duplicateID, mergedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := txPtr.Commit(); err != nil {
+		return nil, err
+	}
+	return &merge, nil
+}
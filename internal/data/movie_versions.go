@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*********************************************************************************************************************/
+/*
+MOVIE VERSION STRUCT
+A snapshot of a movie as it was at a given version, along with who made the edit that produced it and when.
+EditedBy is nil for versions that predate the history table and for users that have since been deleted.
+*/
+type MovieVersion struct {
+	MovieID       int64     `json:"movie_id"`
+	Version       int32     `json:"version"`
+	Title         string    `json:"title"`
+	Year          int32     `json:"year"`
+	Runtime       Runtime   `json:"runtime"`
+	Genres        []string  `json:"genres"`
+	Status        string    `json:"status"`
+	EditedBy      *int64    `json:"edited_by"`
+	EditedAt      time.Time `json:"edited_at"`
+	TotalVersions int       `json:"-"` //total versions of the movie, see notes(2) in movies.go
+}
+
+/*********************************************************************************************************************/
+/*
+MOVIE VERSION MODEL
+The snapshots themselves are written by the MovieModel (and the GenreModel when a rename rewrites movies) in the
+same statement that bumps a movie's version, read notes(1). This model only reads them.
+*/
+type MovieVersionModel struct {
+	DBPtr *sql.DB
+}
+
+// snapshotMovies returns a statement which records the current state of the movies selected from source as a
+// new version edited by the user in parameter editedByParam. source is usually the name of a data-modifying
+// CTE that RETURNs the movies' columns, so that the snapshot is taken in the same statement as the change.
+func snapshotMovies(source string, editedByParam int) string {
+	return fmt.Sprintf(`
+		INSERT INTO movie_versions (movie_id, version, title, year, runtime, genres, status, edited_by)
+		SELECT id, version, title, year, runtime, genres, status, $%d FROM %s
+	`, editedByParam, source)
+}
+
+/*
+GET ALL VERSIONS OF A MOVIE - newest first, paginated with the same Filters we use for movies
+*/
+func (movieVersionModel MovieVersionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieVersion, PageMetadata, error) {
+	query := `
+		SELECT COUNT(*) OVER(), movie_id, version, title, year, runtime, genres, status, edited_by, edited_at
+		FROM movie_versions
+		WHERE movie_id = $1
+		ORDER BY version DESC
+		OFFSET $2 LIMIT $3
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	versionRows, err := movieVersionModel.DBPtr.QueryContext(ctx, query, movieID, filters.offset(), filters.limit())
+	if err != nil {
+		return nil, PageMetadata{}, err
+	}
+	defer versionRows.Close()
+
+	versionPtrs := []*MovieVersion{}
+	for versionRows.Next() {
+		var movieVersion MovieVersion
+		err := versionRows.Scan(
+			&movieVersion.TotalVersions,
+			&movieVersion.MovieID, &movieVersion.Version, &movieVersion.Title, &movieVersion.Year,
+			&movieVersion.Runtime, pq.Array(&movieVersion.Genres), &movieVersion.Status,
+			&movieVersion.EditedBy, &movieVersion.EditedAt,
+		)
+		if err != nil {
+			return nil, PageMetadata{}, err
+		}
+		versionPtrs = append(versionPtrs, &movieVersion)
+	}
+	if err := versionRows.Err(); err != nil {
+		return nil, PageMetadata{}, err
+	}
+
+	totalRecords := 0
+	if len(versionPtrs) > 0 {
+		totalRecords = versionPtrs[0].TotalVersions
+	}
+	return versionPtrs, CalculatePageMetadata(totalRecords, filters.PageSize, filters.Page), nil
+}
+
+/*
+GET A VERSION OF A MOVIE
+*/
+func (movieVersionModel MovieVersionModel) GetVersion(movieID int64, version int32) (*MovieVersion, error) {
+	if version < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+		SELECT movie_id, version, title, year, runtime, genres, status, edited_by, edited_at
+		FROM movie_versions
+		WHERE movie_id = $1 AND version = $2
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var movieVersion MovieVersion
+	err := movieVersionModel.DBPtr.QueryRowContext(ctx, query, movieID, version).Scan(
+		&movieVersion.MovieID,
+		&movieVersion.Version,
+		&movieVersion.Title,
+		&movieVersion.Year,
+		&movieVersion.Runtime,
+		pq.Array(&movieVersion.Genres),
+		&movieVersion.Status,
+		&movieVersion.EditedBy,
+		&movieVersion.EditedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movieVersion, nil
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES:
+1 - TAKING SNAPSHOTS
+Every statement that bumps a movie's version is written as a data-modifying CTE, e.g.
+
+	WITH updated AS (UPDATE movies SET ... RETURNING id, version, title, ...)
+	INSERT INTO movie_versions (...) SELECT ... FROM updated
+
+so the new version and its snapshot are written together or not at all, without a transaction round trip. When the
+UPDATE matches no rows (a stale version, a movie in the trash) there is nothing to snapshot either. The snapshot
+holds the movie's full state rather than a diff, which makes reading a version and reverting to it a single lookup.
+*/
@@ -0,0 +1,39 @@
+package data
+
+import "testing"
+
+func TestPrefixTSQuery(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"star wa", "star:* & wa:*"},
+		{"Star", "Star:*"},
+		{"  star   wars  ", "star:* & wars:*"},
+		{"2001", "2001:*"},
+		{"Amélie", "Amélie:*"},
+		{"l'amour", "l:* & amour:*"},
+		// tsquery operators are dropped, not passed through
+		{"star & !wars | (empire)", "star:* & wars:* & empire:*"},
+		{"a:*b", "a:* & b:*"},
+		{"<b>jaws</b>", "b:* & jaws:* & b:*"},
+		{"", ""},
+		{"!&|", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			if got := prefixTSQuery(test.title); got != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeHTMLExpression(t *testing.T) {
+	// & is replaced first, or the & of the entities for < and > would be escaped again
+	want := "replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')"
+	if got := escapeHTMLExpression("title"); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,349 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/validator"
+	"time"
+)
+
+// The roles a person can be credited with on a movie
+const (
+	CreditDirector = "director"
+	CreditActor    = "actor"
+	CreditWriter   = "writer"
+)
+
+var CreditRoles = []string{CreditDirector, CreditActor, CreditWriter}
+
+// Define a custom ErrDuplicateCredit error for when the same person is credited with
+// the same role (and character) on a movie twice.
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+)
+
+/*********************************************************************************************************************/
+// PERSON STRUCT
+// Someone who worked on movies e.g. a director, an actor or a writer
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Version   int32     `json:"version"`
+	//total people matching the listing query, see notes(2) in movies.go
+	TotalPeople int `json:"-"`
+}
+
+// CREDIT STRUCT
+// The role a person had on a movie, and the character they played if they were an actor.
+// Name is the person's name, read from the people table for convenience.
+type Credit struct {
+	ID        int64  `json:"id"`
+	MovieID   int64  `json:"movie_id"`
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+// PERSON MODEL
+// Create a PersonModel struct which wraps the connection pool.
+type PersonModel struct {
+	DBPtr *sql.DB
+}
+
+// CREDIT MODEL
+// Create a CreditModel struct which wraps the connection pool.
+type CreditModel struct {
+	DBPtr *sql.DB
+}
+
+/*********************************************************************************************************************/
+/*
+VALIDATE PERSON
+*/
+func ValidatePerson(personValidatorPtr *validator.Validator, personPtr *Person) {
+	personValidatorPtr.Check(personPtr.Name != "", "name", "cannot be empty")
+	personValidatorPtr.Check(len(personPtr.Name) <= 500, "name", "cannot be more than 500 bytes long")
+
+	// birth year is optional, but must be sensible if it is provided
+	personValidatorPtr.Check(
+		personPtr.BirthYear == 0 || (personPtr.BirthYear >= 1800 && int(personPtr.BirthYear) <= time.Now().Year()),
+		"birth_year",
+		fmt.Sprintf("must be from 1800 to %d", time.Now().Year()),
+	)
+}
+
+/*
+VALIDATE CREDIT
+*/
+func ValidateCredit(creditValidatorPtr *validator.Validator, creditPtr *Credit) {
+	creditValidatorPtr.Check(creditPtr.PersonID > 0, "person_id", "must be provided")
+	creditValidatorPtr.Check(
+		validator.PermittedValue(creditPtr.Role, CreditRoles...),
+		"role",
+		fmt.Sprintf("must be one of the following: %+v", CreditRoles),
+	)
+	// only actors play characters
+	creditValidatorPtr.Check(
+		creditPtr.Character == "" || creditPtr.Role == CreditActor,
+		"character",
+		"can only be provided for actors",
+	)
+	creditValidatorPtr.Check(len(creditPtr.Character) <= 500, "character", "cannot be more than 500 bytes long")
+}
+
+/*********************************************************************************************************************/
+/*
+PERSON MODEL DB INTERACTIONS (CRUD)
+*/
+/*
+CREATE (INSERT) PERSON
+*/
+func (personModel PersonModel) InsertPerson(personPtr *Person) error {
+	query := `
+		INSERT INTO people(name, birth_year)
+		VALUES($1, $2) RETURNING id, created_at, version
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	return personModel.DBPtr.QueryRowContext(ctx, query, personPtr.Name, personPtr.BirthYear).Scan(
+		&personPtr.ID, &personPtr.CreatedAt, &personPtr.Version,
+	)
+}
+
+/*
+READ (GET) PERSON
+*/
+func (personModel PersonModel) GetPerson(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, birth_year, version
+		FROM people
+		WHERE id = $1
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var person Person
+	err := personModel.DBPtr.QueryRowContext(ctx, query, id).Scan(
+		&person.ID, &person.CreatedAt, &person.Name, &person.BirthYear, &person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &person, nil
+}
+
+/*
+UPDATE PERSON - with the same optimistic concurrency check on the version we use for movies
+*/
+func (personModel PersonModel) UpdatePerson(personPtr *Person) error {
+	query := `
+		UPDATE people
+		SET name = $1, birth_year = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	err := personModel.DBPtr.QueryRowContext(
+		ctx, query, personPtr.Name, personPtr.BirthYear, personPtr.ID, personPtr.Version,
+	).Scan(&personPtr.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+DELETE PERSON - their credits are deleted along with them (ON DELETE CASCADE)
+*/
+func (personModel PersonModel) DeletePerson(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	result, err := personModel.DBPtr.ExecContext(ctx, `DELETE FROM people WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*
+GET ALL PEOPLE - search people by name (prefix matching like movie titles), paginated and sorted with Filters
+*/
+func (personModel PersonModel) GetAllPeople(name string, filters Filters) ([]*Person, PageMetadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, birth_year, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ to_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s
+		OFFSET $2 LIMIT $3
+	`, filters.orderBy(filters.sortColumn()))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	personRows, err := personModel.DBPtr.QueryContext(ctx, query, prefixTSQuery(name), filters.offset(), filters.limit())
+	if err != nil {
+		return nil, PageMetadata{}, err
+	}
+	defer personRows.Close()
+
+	personPtrs := []*Person{}
+	for personRows.Next() {
+		var person Person
+		err := personRows.Scan(
+			&person.TotalPeople, &person.ID, &person.CreatedAt, &person.Name, &person.BirthYear, &person.Version,
+		)
+		if err != nil {
+			return nil, PageMetadata{}, err
+		}
+		personPtrs = append(personPtrs, &person)
+	}
+
+	if err := personRows.Err(); err != nil {
+		return nil, PageMetadata{}, err
+	}
+
+	totalRecords := 0
+	if len(personPtrs) > 0 {
+		totalRecords = personPtrs[0].TotalPeople
+	}
+	return personPtrs, CalculatePageMetadata(totalRecords, filters.PageSize, filters.Page), nil
+}
+
+/*********************************************************************************************************************/
+/*
+CREDIT MODEL DB INTERACTIONS
+*/
+/*
+CREATE (INSERT) CREDIT - Credit a person on a movie. We return ErrRecordNotFound if the person doesn't exist and
+ErrDuplicateCredit if they already have this exact credit.
+*/
+func (creditModel CreditModel) InsertCredit(creditPtr *Credit) error {
+	query := `
+		INSERT INTO credits(movie_id, person_id, role, character_name)
+		VALUES($1, $2, $3, $4)
+		RETURNING id, (SELECT name FROM people WHERE id = $2)
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	err := creditModel.DBPtr.QueryRowContext(
+		ctx, query, creditPtr.MovieID, creditPtr.PersonID, creditPtr.Role, creditPtr.Character,
+	).Scan(&creditPtr.ID, &creditPtr.Name)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "credits" violates foreign key constraint "credits_person_id_fkey"`:
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "credits_movie_id_person_id_role_character_name_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+DELETE CREDIT - Delete a credit from a movie
+*/
+func (creditModel CreditModel) DeleteCredit(movieID, creditID int64) error {
+	if movieID < 1 || creditID < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	result, err := creditModel.DBPtr.ExecContext(
+		ctx, `DELETE FROM credits WHERE id = $1 AND movie_id = $2`, creditID, movieID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*
+GET ALL CREDITS FOR A MOVIE - directors first, then writers, then the cast in the order they were credited
+*/
+func (creditModel CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT credits.id, credits.movie_id, credits.person_id, people.name, credits.role, credits.character_name
+		FROM credits
+		INNER JOIN people ON people.id = credits.person_id
+		WHERE credits.movie_id = $1
+		ORDER BY array_position(ARRAY['director', 'writer', 'actor'], credits.role), credits.id
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	creditRows, err := creditModel.DBPtr.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer creditRows.Close()
+
+	creditPtrs := []*Credit{}
+	for creditRows.Next() {
+		var credit Credit
+		err := creditRows.Scan(
+			&credit.ID, &credit.MovieID, &credit.PersonID, &credit.Name, &credit.Role, &credit.Character,
+		)
+		if err != nil {
+			return nil, err
+		}
+		creditPtrs = append(creditPtrs, &credit)
+	}
+
+	if err := creditRows.Err(); err != nil {
+		return nil, err
+	}
+	return creditPtrs, nil
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/validator"
+	"regexp"
+	"time"
+)
+
+// Define a custom ErrReleaseYear error for when a change to a movie's release dates would move its year (the year of
+// its first release) past the latest year its status allows, see LatestMovieYear.
+var (
+	ErrReleaseYear = errors.New("release year not allowed by status")
+)
+
+/*********************************************************************************************************************/
+/*
+MOVIE STATUS
+The stage a movie is at. A movie is announced before shooting starts, in production until it is done and released
+once it has come out somewhere. MovieStatuses holds them all.
+*/
+const (
+	MovieStatusAnnounced    = "announced"
+	MovieStatusInProduction = "in_production"
+	MovieStatusReleased     = "released"
+)
+
+var MovieStatuses = []string{MovieStatusAnnounced, MovieStatusInProduction, MovieStatusReleased}
+
+// How many years from now a movie that isn't released yet can be due out in, read notes(13) in movies.go
+const MovieAnnouncedYearsAhead = 10
+
+/*********************************************************************************************************************/
+/*
+MOVIE RELEASE STRUCT
+The date a movie comes out in a country. Region is an ISO 3166-1 alpha-2 code e.g. "GB", Date is in the form
+2006-01-02.
+*/
+type MovieRelease struct {
+	Region string `json:"region"`
+	Date   string `json:"date"`
+}
+
+// RegionRX matches a region as we store it, upper case
+var RegionRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+/*********************************************************************************************************************/
+/*
+MOVIE RELEASE MODEL
+*/
+type MovieReleaseModel struct {
+	DBPtr *sql.DB
+}
+
+/*
+GET ALL RELEASES FOR A MOVIE - earliest first
+*/
+func (releaseModel MovieReleaseModel) GetAllForMovie(movieID int64) ([]*MovieRelease, error) {
+	query := `
+		SELECT region, release_date::text
+		FROM movie_releases
+		WHERE movie_id = $1
+		ORDER BY release_date ASC, region ASC
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	releaseRows, err := releaseModel.DBPtr.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseRows.Close()
+
+	releasePtrs := []*MovieRelease{}
+	for releaseRows.Next() {
+		var release MovieRelease
+		if err := releaseRows.Scan(&release.Region, &release.Date); err != nil {
+			return nil, err
+		}
+		releasePtrs = append(releasePtrs, &release)
+	}
+	if err := releaseRows.Err(); err != nil {
+		return nil, err
+	}
+	return releasePtrs, nil
+}
+
+/*
+SET RELEASE - Give a movie its release date in a region on behalf of the user editedBy, replacing the date it had
+there before if it had one. Reports whether the movie had no date in that region yet. The movie's year is derived
+from its release dates again and its version is bumped, its Year and Version are updated. A non-zero
+expectedVersion makes the change conditional: ErrEditConflict is returned if the movie isn't at that version any
+more. Returns ErrRecordNotFound if the movie doesn't exist or is in the trash, and ErrReleaseYear if the date would
+make a released movie come out in a year that hasn't come yet. Read notes(13) in movies.go
+*/
+func (releaseModel MovieReleaseModel) SetRelease(moviePtr *Movie, releasePtr *MovieRelease, expectedVersion int32, editedBy int64) (bool, error) {
+	// xmax is only 0 on a row this statement inserted, read UpsertTranslation
+	query := `
+		INSERT INTO movie_releases (movie_id, region, release_date)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, region) DO UPDATE
+		SET release_date = EXCLUDED.release_date
+		RETURNING (xmax = 0)
+	`
+
+	var created bool
+	err := releaseModel.changeReleases(moviePtr, expectedVersion, editedBy, func(ctx context.Context, txPtr *sql.Tx) error {
+		return txPtr.QueryRowContext(ctx, query, moviePtr.ID, releasePtr.Region, releasePtr.Date).Scan(&created)
+	})
+	return created, err
+}
+
+/*
+DELETE RELEASE - Remove the release date a movie has in a region on behalf of the user editedBy, deriving the movie's
+year again and bumping its version like SetRelease, expectedVersion too is checked like there. A movie that no longer
+has any release date keeps the year it had. Returns ErrReleaseYear if the release dates left would make a released
+movie come out in a year that hasn't come yet.
+*/
+func (releaseModel MovieReleaseModel) DeleteRelease(moviePtr *Movie, region string, expectedVersion int32, editedBy int64) error {
+	query := `
+		DELETE FROM movie_releases
+		WHERE movie_id = $1 AND region = $2
+	`
+
+	return releaseModel.changeReleases(moviePtr, expectedVersion, editedBy, func(ctx context.Context, txPtr *sql.Tx) error {
+		result, err := txPtr.ExecContext(ctx, query, moviePtr.ID, region)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// changeReleases runs change, which adds, changes or removes a release date of the movie, derives the movie's year
+// from its release dates again and bumps its version, in one transaction. The movie is locked first so that two
+// requests changing its release dates at once can't both derive the year from what was there before either of them,
+// and the expectedVersion is checked under that lock, two changes made on the same version can't both go through.
+func (releaseModel MovieReleaseModel) changeReleases(moviePtr *Movie, expectedVersion int32, editedBy int64, change func(context.Context, *sql.Tx) error) error {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	txPtr, err := releaseModel.DBPtr.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer txPtr.Rollback()
+
+	var version int32
+	err = txPtr.QueryRowContext(ctx, `
+		SELECT version FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, moviePtr.ID).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	if expectedVersion != 0 && version != expectedVersion {
+		return ErrEditConflict
+	}
+
+	if err := change(ctx, txPtr); err != nil {
+		return err
+	}
+
+	// the year the movie would now have must be one its status allows, as ValidateMovie checks it on every update,
+	// or the movie couldn't be edited any more
+	var releaseYear *int32
+	var status string
+	err = txPtr.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT (%s), status FROM movies WHERE id = $1
+	`, releaseYearQuery("$1")), moviePtr.ID).Scan(&releaseYear, &status)
+	if err != nil {
+		return err
+	}
+	if releaseYear != nil && int(*releaseYear) > LatestMovieYear(status) {
+		return ErrReleaseYear
+	}
+
+	// a new version even when the year stays, the movie's ETag has to change with its release dates
+	err = txPtr.QueryRowContext(ctx, deriveYearQuery(true), moviePtr.ID, editedBy).Scan(&moviePtr.Year, &moviePtr.Version)
+	if err != nil {
+		return err
+	}
+
+	return txPtr.Commit()
+}
+
+// deriveYearQuery is the statement that sets the year of the movie $1 to the year of its first release, on behalf of
+// the user $2, and returns the movie's new year and version. A movie whose year doesn't change is left as it is, no
+// new version and no row returned, unless bumpVersion asks for a new version anyway (a merge or a change of release
+// dates changes the movie even when it keeps its year). A movie without release dates keeps whatever year it was given
+func deriveYearQuery(bumpVersion bool) string {
+	condition := fmt.Sprintf("AND year <> COALESCE((%s), year)", releaseYearQuery("$1"))
+	if bumpVersion {
+		condition = ""
+	}
+	return fmt.Sprintf(`
+		WITH updated AS (
+			UPDATE movies SET year = COALESCE((%[1]s), year), version = version + 1
+			WHERE id = $1 %[2]s
+			RETURNING id, version, title, year, runtime, genres, status
+		), snapshot AS (%[3]s)
+		SELECT year, version FROM updated
+	`, releaseYearQuery("$1"), condition, snapshotMovies("updated", 2))
+}
+
+/*********************************************************************************************************************/
+/*
+VALIDATE RELEASE
+The region must be a two letter country code and the date a day from 1888 up to MovieAnnouncedYearsAhead years from
+now. Whether the movie's status allows the year the date gives it is only known once it is saved, see SetRelease.
+*/
+func ValidateRelease(releaseValidatorPtr *validator.Validator, releasePtr *MovieRelease) {
+	releaseValidatorPtr.Check(
+		validator.Matches(releasePtr.Region, RegionRX),
+		"region",
+		"must be a two letter country code e.g. GB",
+	)
+
+	date, err := time.Parse(time.DateOnly, releasePtr.Date)
+	if err != nil {
+		releaseValidatorPtr.AddError("date", "must be a date in the form 2006-01-02")
+		return
+	}
+	releaseValidatorPtr.Check(date.Year() >= 1888, "date", "must not be before 1888")
+	releaseValidatorPtr.Check(
+		date.Before(time.Now().AddDate(MovieAnnouncedYearsAhead, 0, 0)),
+		"date",
+		fmt.Sprintf("must be within %d years from now", MovieAnnouncedYearsAhead),
+	)
+}
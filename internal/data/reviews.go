@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight-movie-api/internal/validator"
+	"time"
+)
+
+// Define a custom ErrDuplicateReview error. A user can only review a movie once, they
+// edit their existing review afterwards.
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+/*********************************************************************************************************************/
+// REVIEW STRUCT
+// A user's rating (1-10) and optional write-up of a movie.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+	//total reviews matching the listing query, see notes(2) in movies.go
+	TotalReviews int `json:"-"`
+}
+
+// REVIEW MODEL
+// Create a ReviewModel struct which wraps the connection pool.
+type ReviewModel struct {
+	DBPtr *sql.DB
+}
+
+/*********************************************************************************************************************/
+/*
+VALIDATE REVIEW
+*/
+func ValidateReview(reviewValidatorPtr *validator.Validator, reviewPtr *Review) {
+	reviewValidatorPtr.Check(
+		reviewPtr.Rating >= 1 && reviewPtr.Rating <= 10,
+		"rating",
+		"must be from (including) 1 upto (including) 10",
+	)
+	reviewValidatorPtr.Check(
+		len(reviewPtr.Body) <= 10_000,
+		"body",
+		"must not be more than 10,000 bytes long",
+	)
+}
+
+/*********************************************************************************************************************/
+/*
+REVIEW MODEL DB INTERACTIONS (CRUD)
+*/
+/*
+CREATE (INSERT) REVIEW - Insert a user's review of a movie. We return ErrDuplicateReview if the user has
+already reviewed this movie (violation of the reviews_movie_id_user_id_key constraint), and ErrRecordNotFound if
+the movie is in the trash (it may have been deleted since the handler read it).
+*/
+func (reviewModel ReviewModel) InsertReview(reviewPtr *Review) error {
+	query := `
+		INSERT INTO reviews(movie_id, user_id, rating, body)
+		SELECT $1, $2, $3, $4
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)
+		RETURNING id, created_at, version
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	err := reviewModel.DBPtr.QueryRowContext(
+		ctx, query, reviewPtr.MovieID, reviewPtr.UserID, reviewPtr.Rating, reviewPtr.Body,
+	).Scan(&reviewPtr.ID, &reviewPtr.CreatedAt, &reviewPtr.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+READ (GET) REVIEW - Get the review a specific user wrote for a specific movie. The reviews of a movie in the trash
+aren't found until the movie is restored, and can't be edited or deleted meanwhile.
+*/
+func (reviewModel ReviewModel) GetReview(movieID, userID int64) (*Review, error) {
+	if movieID < 1 || userID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE movie_id = $1 AND user_id = $2
+		AND EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var review Review
+	err := reviewModel.DBPtr.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &review, nil
+}
+
+/*
+UPDATE REVIEW - Update the rating and body of a review, using the same optimistic concurrency check on the version
+that we use when updating a movie (see notes(1) in movies.go). A movie moved to the trash since the review was read
+is a conflict as well.
+*/
+func (reviewModel ReviewModel) UpdateReview(reviewPtr *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		AND EXISTS (SELECT 1 FROM movies WHERE id = reviews.movie_id AND deleted_at IS NULL)
+		RETURNING version
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	err := reviewModel.DBPtr.QueryRowContext(
+		ctx, query, reviewPtr.Rating, reviewPtr.Body, reviewPtr.ID, reviewPtr.Version,
+	).Scan(&reviewPtr.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+DELETE REVIEW - Delete the review a specific user wrote for a specific movie, unless the movie is in the trash
+*/
+func (reviewModel ReviewModel) DeleteReview(movieID, userID int64) error {
+	if movieID < 1 || userID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM reviews
+		WHERE movie_id = $1 AND user_id = $2
+		AND EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	result, err := reviewModel.DBPtr.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	// If no rows were affected, we know that the user hadn't reviewed the movie
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*
+GET ALL REVIEWS FOR A MOVIE - paginated and sorted with the same Filters we use for movies
+*/
+func (reviewModel ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, PageMetadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE movie_id = $1
+		ORDER BY %s
+		OFFSET $2 LIMIT $3
+	`, filters.orderBy(filters.sortColumn()))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	reviewRows, err := reviewModel.DBPtr.QueryContext(ctx, query, movieID, filters.offset(), filters.limit())
+	if err != nil {
+		return nil, PageMetadata{}, err
+	}
+	defer reviewRows.Close()
+
+	reviewPtrs := []*Review{}
+	for reviewRows.Next() {
+		var review Review
+		err := reviewRows.Scan(
+			&review.TotalReviews,
+			&review.ID, &review.CreatedAt, &review.MovieID, &review.UserID,
+			&review.Rating, &review.Body, &review.Version,
+		)
+		if err != nil {
+			return nil, PageMetadata{}, err
+		}
+		reviewPtrs = append(reviewPtrs, &review)
+	}
+
+	if err := reviewRows.Err(); err != nil {
+		return nil, PageMetadata{}, err
+	}
+
+	totalRecords := 0
+	if len(reviewPtrs) > 0 {
+		totalRecords = reviewPtrs[0].TotalReviews
+	}
+	return reviewPtrs, CalculatePageMetadata(totalRecords, filters.PageSize, filters.Page), nil
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*********************************************************************************************************************/
@@ -19,19 +21,58 @@ type Runtime int32
 /*********************************************************************************************************************/
 // Define an error that our UnmarshalJSON() method can return if we're unable to parse
 // or convert the JSON string successfully.
-var ErrInvalidRuntimeFormat = errors.New(`runtime should be in the format: "<runtime> mins", where runtime is a valid int`)
+var ErrInvalidRuntimeFormat = errors.New(
+	`runtime should be a whole number of minutes e.g. 90, "90 mins", "1h30m", "1:30:00" or "PT1H30M"`,
+)
+
+/*********************************************************************************************************************/
+/*
+RUNTIME FORMATS
+How a runtime is written out in JSON, picked by the client with ?runtime_format. Human is "90 mins", what the API has
+always sent, minutes is the plain number 90 and iso8601 is the duration "PT1H30M".
+*/
+type RuntimeFormat string
+
+const (
+	RuntimeFormatHuman   RuntimeFormat = "human"
+	RuntimeFormatMinutes RuntimeFormat = "minutes"
+	RuntimeFormatISO8601 RuntimeFormat = "iso8601"
+)
+
+// RuntimeFormats are the values ?runtime_format accepts
+var RuntimeFormats = []string{string(RuntimeFormatHuman), string(RuntimeFormatMinutes), string(RuntimeFormatISO8601)}
 
 /*********************************************************************************************************************/
 /*CUSTOM MARSHALJSON FUNC*/
 func (r Runtime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Format(RuntimeFormatHuman))
+}
 
-	//Convert r into a string
-	stringForm := strconv.FormatInt(int64(r), 10)
-	// strconv.Itoa()
-	//Format the returned string into a custom string e.g. "64 mins"
-	finalRep := fmt.Sprintf("%s mins", stringForm)
-	fmt.Println(finalRep)
-	return json.Marshal(finalRep)
+/*
+FORMAT RUNTIME - The value a runtime is written out as in the given format, a string or (for minutes) a number. An
+unset runtime gives nil, so that omitempty leaves it out the same way it leaves out a Runtime of 0.
+*/
+func (r Runtime) Format(format RuntimeFormat) any {
+	if r == 0 {
+		return nil
+	}
+	switch format {
+	case RuntimeFormatMinutes:
+		return int32(r)
+	case RuntimeFormatISO8601:
+		hours, minutes := r/60, r%60
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("PT%dM", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("PT%dH", hours)
+		default:
+			return fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+	default:
+		//Format the runtime into a custom string e.g. "64 mins"
+		return fmt.Sprintf("%s mins", strconv.FormatInt(int64(r), 10))
+	}
 }
 
 /*********************************************************************************************************************/
@@ -40,33 +81,139 @@ CUSTOM UNMARSHALJSON FUNC
 Refer to notes for info on challenges i faced debugging this issue
 */
 func (rPtr *Runtime) UnmarshalJSON(jsonForm []byte) error {
-	var stringForm string
+	//a plain JSON number is a number of minutes
+	var intForm int32
+	if err := json.Unmarshal(jsonForm, &intForm); err == nil {
+		*rPtr = Runtime(intForm)
+		return nil
+	}
 
 	//unmarshal the json value into a string
+	var stringForm string
 	if err := json.Unmarshal(jsonForm, &stringForm); err != nil {
 		return ErrInvalidRuntimeFormat
 	}
 
-	//check if stringform has suffix " mins", return an error if it doesn't
-	if !strings.HasSuffix(stringForm, " mins") {
-		return ErrInvalidRuntimeFormat
+	//set the value that the ptr points to as the runtime
+	runtime, err := ParseRuntime(stringForm)
+	if err != nil {
+		return err
 	}
+	*rPtr = runtime
+	return nil
+}
 
-	//trim the " mins" suffix from stringform, it should now have simply a number
-	//in string form i.e from "56 mins" to "56"
-	stringForm = strings.TrimSuffix(stringForm, " mins")
+/*
+PARSE RUNTIME - Read a runtime written in any of the forms we accept: "90", "90 mins", "1h30m" (hours and minutes the
+way Go writes durations), "1:30:00" or "1:30" (hours, minutes and seconds) or an ISO 8601 duration "PT1H30M". Read
+notes(2)
+*/
+func ParseRuntime(stringForm string) (Runtime, error) {
+	stringForm = strings.TrimSpace(stringForm)
+
+	var duration time.Duration
+	var err error
+	switch {
+	case strings.HasPrefix(strings.ToUpper(stringForm), "P"):
+		duration, err = parseISO8601Duration(strings.ToUpper(stringForm))
+	case strings.Contains(stringForm, ":"):
+		duration, err = parseClockDuration(stringForm)
+	case strings.ContainsAny(stringForm, "hms"):
+		//"90 mins" has its own suffix check, the rest goes to time.ParseDuration e.g. "1h30m", "95m"
+		if minutes, found := strings.CutSuffix(stringForm, " mins"); found {
+			duration, err = parseMinutes(minutes)
+		} else {
+			duration, err = time.ParseDuration(stringForm)
+		}
+	default:
+		duration, err = parseMinutes(stringForm)
+	}
+	if err != nil || duration <= 0 || duration%time.Minute != 0 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+	return Runtime(duration / time.Minute), nil
+}
 
+// parseMinutes reads a plain number of minutes e.g. "90"
+func parseMinutes(stringForm string) (time.Duration, error) {
 	//Convert string to valid int e.g. "56" to 56, return an error if we can't convert
 	//the string representation to a valid int. It means the client did not send a valid
 	//integer for the runtime value
 	intForm, err := strconv.ParseInt(stringForm, 10, 32)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, err
 	}
+	return addDuration(0, intForm, time.Minute)
+}
 
-	//set the value that the ptr points to as the runtime
-	*rPtr = Runtime(intForm)
-	return nil
+// parseClockDuration reads "h:mm" or "h:mm:ss" e.g. "1:30:00"
+func parseClockDuration(stringForm string) (time.Duration, error) {
+	parts := strings.Split(stringForm, ":")
+	if len(parts) > 3 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, part := range parts {
+		value, err := strconv.ParseInt(part, 10, 32)
+		// the minutes and seconds are always written with 2 digits and can't go past 59
+		if err != nil || value < 0 || (i > 0 && (len(part) != 2 || value > 59)) {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		if duration, err = addDuration(duration, value, units[i]); err != nil {
+			return 0, err
+		}
+	}
+	return duration, nil
+}
+
+// parseISO8601Duration reads the days and time parts of an ISO 8601 duration e.g. "PT1H30M", "PT90M" or "P1DT2H".
+// Years, months and weeks have no fixed length and aren't accepted.
+func parseISO8601Duration(stringForm string) (time.Duration, error) {
+	rest, found := strings.CutPrefix(stringForm, "P")
+	if !found || rest == "" {
+		return 0, ErrInvalidRuntimeFormat
+	}
+	designators := "D"
+	units := map[byte]time.Duration{'D': 24 * time.Hour}
+	inTime := false
+	var duration time.Duration
+	for rest != "" {
+		if rest[0] == 'T' && !inTime {
+			// the time part needs at least one value after it
+			if rest = rest[1:]; rest == "" {
+				return 0, ErrInvalidRuntimeFormat
+			}
+			inTime = true
+			designators = "HMS"
+			units = map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+		if end <= 0 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		value, err := strconv.ParseInt(rest[:end], 10, 32)
+		// every designator appears once, in order
+		position := strings.IndexByte(designators, rest[end])
+		if err != nil || position < 0 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		if duration, err = addDuration(duration, value, units[rest[end]]); err != nil {
+			return 0, err
+		}
+		designators = designators[position+1:]
+		rest = rest[end+1:]
+	}
+	return duration, nil
+}
+
+// addDuration adds value units to duration, failing rather than overflowing on an absurdly long runtime
+func addDuration(duration time.Duration, value int64, unit time.Duration) (time.Duration, error) {
+	if value > int64((math.MaxInt64-duration)/unit) {
+		return 0, ErrInvalidRuntimeFormat
+	}
+	return duration + time.Duration(value)*unit, nil
 }
 
 /*********************************************************************************************************************/
@@ -91,4 +238,12 @@ UnmarshalJSON method using a value receiver, therefore the method was operating
 to this value, was not evident outside the method, simple yet tricky. The solution was to declare it as a pointer receiver,
 that way, when the method is called, an address to the runtime value is passed, thus any change i make in the UnmarshalJSON
 method, actually changes the value outside the method. Phew, I learnt a lot.
+
+2. RUNTIME FORMATS
+A runtime used to only be accepted as "90 mins", which meant every integration had to build and parse that string. It is
+now also accepted as a plain number of minutes, or as a duration in the forms other systems commonly hand out ("1h30m",
+"1:30:00", "PT1H30M"), all of them turned into minutes by ParseRuntime, which the CSV import uses too. A runtime is
+stored in whole minutes, so a duration that isn't a whole number of minutes e.g. "1:30:30" is rejected rather than
+rounded. Output stays "90 mins" unless the client asks otherwise with ?runtime_format, which every endpoint that sends
+movies back takes (a CSV export always has the runtime in minutes, the way the import reads it).
 */
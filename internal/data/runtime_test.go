@@ -0,0 +1,152 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		stringForm string
+		want       Runtime
+	}{
+		{"90", 90},
+		{" 90 ", 90},
+		{"90 mins", 90},
+		{"1h30m", 90},
+		{"95m", 95},
+		{"2h", 120},
+		{"1:30:00", 90},
+		{"1:30", 90},
+		{"0:45", 45},
+		{"PT1H30M", 90},
+		{"pt1h30m", 90},
+		{"PT90M", 90},
+		{"PT1M60S", 2},
+		{"P1D", 1440},
+		{"P1DT2H", 1560},
+
+		// a runtime is a whole, positive number of minutes
+		{"1:30:30", 0},
+		{"PT30S", 0},
+		{"1h30m15s", 0},
+		{"90.5", 0},
+		{"0", 0},
+		{"-5", 0},
+		{"0:00", 0},
+		// minutes and seconds are 2 digits up to 59
+		{"1:5", 0},
+		{"1:60", 0},
+		{"1:30:60", 0},
+		{"1:30:00:00", 0},
+		{"1:-30", 0},
+		// an ISO 8601 duration needs a value after P and after T, its designators once each and in order
+		{"P", 0},
+		{"PT", 0},
+		{"P1DT", 0},
+		{"PT1H1H", 0},
+		{"PT1M1H", 0},
+		{"PTH", 0},
+		{"PT-1H", 0},
+		{"PT1.5H", 0},
+		// years, months and weeks have no fixed length
+		{"P1Y", 0},
+		{"P1M", 0},
+		{"P1W", 0},
+		// too long to be a duration at all
+		{"PT2147483647H", 0},
+		{"99999999999", 0},
+		{"", 0},
+		{"abc", 0},
+		{"90 min", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.stringForm, func(t *testing.T) {
+			runtime, err := ParseRuntime(test.stringForm)
+			if test.want == 0 {
+				if !errors.Is(err, ErrInvalidRuntimeFormat) {
+					t.Fatalf("got %d, %v; want ErrInvalidRuntimeFormat", runtime, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if runtime != test.want {
+				t.Errorf("got %d; want %d", runtime, test.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeFormat(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		format  RuntimeFormat
+		want    any
+	}{
+		{90, RuntimeFormatHuman, "90 mins"},
+		{90, RuntimeFormatMinutes, int32(90)},
+		{90, RuntimeFormatISO8601, "PT1H30M"},
+		{60, RuntimeFormatISO8601, "PT1H"},
+		{45, RuntimeFormatISO8601, "PT45M"},
+		{1560, RuntimeFormatISO8601, "PT26H"},
+		{0, RuntimeFormatHuman, nil},
+		{0, RuntimeFormatMinutes, nil},
+		{0, RuntimeFormatISO8601, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			if got := test.runtime.Format(test.format); got != test.want {
+				t.Errorf("%d: got %#v; want %#v", test.runtime, got, test.want)
+			}
+		})
+	}
+}
+
+// every format a runtime is written out in is read back as the same runtime
+func TestRuntimeFormatRoundTrip(t *testing.T) {
+	for _, runtime := range []Runtime{1, 45, 60, 90, 1560} {
+		for _, format := range []RuntimeFormat{RuntimeFormatHuman, RuntimeFormatISO8601} {
+			parsed, err := ParseRuntime(runtime.Format(format).(string))
+			if err != nil || parsed != runtime {
+				t.Errorf("%d in %s: got %d, %v", runtime, format, parsed, err)
+			}
+		}
+	}
+}
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		jsonForm string
+		want     Runtime
+	}{
+		{`90`, 90},
+		{`"90 mins"`, 90},
+		{`"PT1H30M"`, 90},
+		{`90.5`, 0},
+		{`true`, 0},
+		{`"1:30:30"`, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.jsonForm, func(t *testing.T) {
+			var runtime Runtime
+			err := runtime.UnmarshalJSON([]byte(test.jsonForm))
+			if test.want == 0 {
+				if err == nil {
+					t.Fatalf("got %d; want an error", runtime)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if runtime != test.want {
+				t.Errorf("got %d; want %d", runtime, test.want)
+			}
+		})
+	}
+}
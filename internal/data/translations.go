@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"greenlight-movie-api/internal/validator"
+	"strings"
+	"time"
+)
+
+/*********************************************************************************************************************/
+// MOVIE TRANSLATION STRUCT
+// The title of a movie in a language other than the one it was released in. Language is a BCP-47 tag such as "fr"
+// or "pt-BR", always kept in its canonical form (see CanonicalLanguageTag).
+type MovieTranslation struct {
+	MovieID   int64     `json:"movie_id"`
+	Language  string    `json:"language"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+/*********************************************************************************************************************/
+/*
+MOVIE TRANSLATION MODEL
+*/
+type MovieTranslationModel struct {
+	DBPtr *sql.DB
+}
+
+/*
+UPSERT TRANSLATION - Set the title of a movie in a language, replacing the translation already there if there is one.
+Reports whether the translation is new.
+*/
+func (movieTranslationModel MovieTranslationModel) UpsertTranslation(translationPtr *MovieTranslation) (bool, error) {
+	// xmax is only 0 on a row this statement inserted, a row it updated carries the id of our transaction in it
+	query := `
+		INSERT INTO movie_translations (movie_id, language, title)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, language) DO UPDATE
+		SET title = EXCLUDED.title, updated_at = NOW()
+		RETURNING created_at, updated_at, (xmax = 0)
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var created bool
+	err := movieTranslationModel.DBPtr.QueryRowContext(
+		ctx, query, translationPtr.MovieID, translationPtr.Language, translationPtr.Title,
+	).Scan(&translationPtr.CreatedAt, &translationPtr.UpdatedAt, &created)
+	return created, err
+}
+
+/*
+GET ALL TRANSLATIONS FOR A MOVIE - ordered by language
+*/
+func (movieTranslationModel MovieTranslationModel) GetAllForMovie(movieID int64) ([]*MovieTranslation, error) {
+	query := `
+		SELECT movie_id, language, title, created_at, updated_at
+		FROM movie_translations
+		WHERE movie_id = $1
+		ORDER BY language ASC
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	translationRows, err := movieTranslationModel.DBPtr.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer translationRows.Close()
+
+	translationPtrs := []*MovieTranslation{}
+	for translationRows.Next() {
+		var translation MovieTranslation
+		err := translationRows.Scan(
+			&translation.MovieID, &translation.Language, &translation.Title,
+			&translation.CreatedAt, &translation.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		translationPtrs = append(translationPtrs, &translation)
+	}
+	if err := translationRows.Err(); err != nil {
+		return nil, err
+	}
+	return translationPtrs, nil
+}
+
+/*
+DELETE TRANSLATION - Remove the title of a movie in a language
+*/
+func (movieTranslationModel MovieTranslationModel) DeleteTranslation(movieID int64, language string) error {
+	query := `
+		DELETE FROM movie_translations
+		WHERE movie_id = $1 AND language = $2
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	result, err := movieTranslationModel.DBPtr.ExecContext(ctx, query, movieID, language)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*********************************************************************************************************************/
+/*
+VALIDATE TRANSLATION
+*/
+func ValidateTranslation(translationValidatorPtr *validator.Validator, translationPtr *MovieTranslation) {
+	_, ok := CanonicalLanguageTag(translationPtr.Language)
+	translationValidatorPtr.Check(ok, "language", "must be a BCP-47 language tag e.g. fr or pt-BR")
+
+	translationValidatorPtr.Check(translationPtr.Title != "", "title", "must be provided")
+	translationValidatorPtr.Check(len([]byte(translationPtr.Title)) <= 500, "title", "must not be more than 500 bytes long")
+}
+
+/*********************************************************************************************************************/
+/*
+LANGUAGE TAGS
+*/
+
+// CanonicalLanguageTag checks that tag is a well formed BCP-47 language tag and returns it with the case its subtags
+// are conventionally written in e.g. "PT-br" -> "pt-BR", "zh-hant-tw" -> "zh-Hant-TW". Only the shape of the tag is
+// checked (a 2 or 3 letter language followed by subtags of up to 8 letters and digits), not that its subtags are
+// registered. Read notes(1)
+func CanonicalLanguageTag(tag string) (string, bool) {
+	subtags := strings.Split(strings.ToLower(tag), "-")
+	if len(subtags) > 8 || len(subtags[0]) < 2 || len(subtags[0]) > 3 || !isAlpha(subtags[0]) {
+		return "", false
+	}
+	// a singleton (e.g. the "x" in "en-x-pirate") can't be the last subtag
+	if len(subtags[len(subtags)-1]) == 1 {
+		return "", false
+	}
+
+	afterSingleton := false
+	for i, subtag := range subtags {
+		if subtag == "" || len(subtag) > 8 || !isAlphanumeric(subtag) {
+			return "", false
+		}
+		switch {
+		// everything from a singleton on stays in lower case
+		case i == 0 || afterSingleton:
+		case len(subtag) == 1:
+			afterSingleton = true
+		// a script e.g. Hant
+		case len(subtag) == 4 && isAlpha(subtag):
+			subtags[i] = strings.ToUpper(subtag[:1]) + subtag[1:]
+		// a region e.g. BR
+		case len(subtag) == 2:
+			subtags[i] = strings.ToUpper(subtag)
+		}
+	}
+	return strings.Join(subtags, "-"), true
+}
+
+// LanguageFallbacks expands the languages a client prefers, best first, into every tag a translation could be stored
+// under that would suit it. Each tag is followed by its less specific forms, so "pt-BR, en" becomes
+// ["pt-BR", "pt", "en"], since a Portuguese title is better than none for a Brazilian reader.
+func LanguageFallbacks(languages []string) []string {
+	fallbacks := []string{}
+	for _, language := range languages {
+		subtags := strings.Split(language, "-")
+		for i := len(subtags); i > 0; i-- {
+			fallback := strings.Join(subtags[:i], "-")
+			// a singleton is never the end of a tag
+			if len(subtags[i-1]) == 1 || validator.PermittedValue(fallback, fallbacks...) {
+				continue
+			}
+			fallbacks = append(fallbacks, fallback)
+		}
+	}
+	return fallbacks
+}
+
+// BestTranslation picks the translation that suits the languages (as expanded by LanguageFallbacks) best, or
+// returns nil if none of them do
+func BestTranslation(translationPtrs []*MovieTranslation, languages []string) *MovieTranslation {
+	for _, language := range languages {
+		for _, translationPtr := range translationPtrs {
+			if translationPtr.Language == language {
+				return translationPtr
+			}
+		}
+	}
+	return nil
+}
+
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+/*********************************************************************************************************************/
+/*
+NOTES:
+1 - LANGUAGE TAGS
+A translation is looked up by comparing tags as strings, so "pt-br" and "pt-BR" must be stored and asked for the same
+way. CanonicalLanguageTag gives every tag the casing RFC 5646 recommends (language lower case, script title case,
+region upper case) before it reaches the database, both when a translation is saved and when a client names the
+languages it reads in the Accept-Language header.
+*/
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanonicalLanguageTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"fr", "fr", true},
+		{"FR", "fr", true},
+		{"PT-br", "pt-BR", true},
+		{"zh-hant-tw", "zh-Hant-TW", true},
+		{"sr-LATN", "sr-Latn", true},
+		{"es-419", "es-419", true},
+		{"de-ch-1996", "de-CH-1996", true},
+		{"yue", "yue", true},
+		// everything from a singleton on stays in lower case
+		{"en-x-pirate", "en-x-pirate", true},
+		{"EN-X-Pirate-GB", "en-x-pirate-gb", true},
+		{"en-a-bbb-x-ab", "en-a-bbb-x-ab", true},
+		// a singleton can't end a tag, or start one
+		{"en-x", "", false},
+		{"en-a-bbb-x", "", false},
+		{"x-pirate", "", false},
+		{"", "", false},
+		{"e", "", false},
+		{"english", "", false},
+		{"e1", "", false},
+		{"en-", "", false},
+		{"en--gb", "", false},
+		{"en_GB", "", false},
+		{"en-abcdefghi", "", false},
+		{"en-a-b-c-d-e-f-gh", "en-a-b-c-d-e-f-gh", true},
+		{"en-a-b-c-d-e-f-g-hi", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			got, ok := CanonicalLanguageTag(test.tag)
+			if got != test.want || ok != test.ok {
+				t.Errorf("got %q, %t; want %q, %t", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestLanguageFallbacks(t *testing.T) {
+	tests := []struct {
+		name      string
+		languages []string
+		want      []string
+	}{
+		{"none", []string{}, []string{}},
+		{"region", []string{"pt-BR", "en"}, []string{"pt-BR", "pt", "en"}},
+		{"script and region", []string{"zh-Hant-TW"}, []string{"zh-Hant-TW", "zh-Hant", "zh"}},
+		{"private use", []string{"en-x-pirate"}, []string{"en-x-pirate", "en"}},
+		{"repeated language", []string{"pt-BR", "pt"}, []string{"pt-BR", "pt"}},
+		{"general before specific", []string{"en", "en-GB"}, []string{"en", "en-GB"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := LanguageFallbacks(test.languages); !slices.Equal(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBestTranslation(t *testing.T) {
+	french := &MovieTranslation{Language: "fr", Title: "La Reine des neiges"}
+	portuguese := &MovieTranslation{Language: "pt", Title: "Frozen: Uma Aventura Congelante"}
+	brazilian := &MovieTranslation{Language: "pt-BR", Title: "Frozen: Uma Aventura Congelante"}
+
+	tests := []struct {
+		name         string
+		translations []*MovieTranslation
+		languages    []string
+		want         *MovieTranslation
+	}{
+		{"exact", []*MovieTranslation{french, brazilian}, LanguageFallbacks([]string{"pt-BR"}), brazilian},
+		{"less specific", []*MovieTranslation{french, portuguese}, LanguageFallbacks([]string{"pt-BR"}), portuguese},
+		{"more specific", []*MovieTranslation{portuguese, brazilian}, LanguageFallbacks([]string{"pt-BR"}), brazilian},
+		{"client's order", []*MovieTranslation{french, portuguese}, []string{"pt", "fr"}, portuguese},
+		{"not more specific than asked", []*MovieTranslation{brazilian}, []string{"pt"}, nil},
+		{"none suits", []*MovieTranslation{french}, []string{"de"}, nil},
+		{"no translations", nil, []string{"fr"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BestTranslation(test.translations, test.languages); got != test.want {
+				t.Errorf("got %+v; want %+v", got, test.want)
+			}
+		})
+	}
+}
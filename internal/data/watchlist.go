@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrDuplicateWatchlistEntry error for when a user adds a movie that
+// is already on their watchlist.
+var (
+	ErrDuplicateWatchlistEntry = errors.New("duplicate watchlist entry")
+)
+
+/*********************************************************************************************************************/
+// WATCHLIST ENTRY STRUCT
+// A movie a user has saved to watch later, and whether they have watched it yet.
+type WatchlistEntry struct {
+	Movie   Movie     `json:"movie"`
+	AddedAt time.Time `json:"added_at"`
+	Watched bool      `json:"watched"`
+	//total entries matching the listing query, see notes(2) in movies.go
+	TotalEntries int `json:"-"`
+}
+
+// WATCHLIST MODEL
+// Create a WatchlistModel struct which wraps the connection pool.
+type WatchlistModel struct {
+	DBPtr *sql.DB
+}
+
+/*********************************************************************************************************************/
+/*
+ADD ENTRY - Add a movie to a user's watchlist (unwatched) and return the entry with the movie, as GetAllForUser
+lists it. We return ErrRecordNotFound if the movie doesn't exist or is in the trash and ErrDuplicateWatchlistEntry
+if it is already on the watchlist.
+*/
+func (watchlistModel WatchlistModel) AddEntry(userID, movieID int64) (*WatchlistEntry, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		WITH added AS (
+			INSERT INTO watchlist(user_id, movie_id)
+			SELECT $1, $2
+			WHERE EXISTS (SELECT 1 FROM movies WHERE id = $2 AND deleted_at IS NULL)
+			RETURNING movie_id, added_at, watched
+		)
+		%s
+	`, watchlistEntryQuery("added"))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var entry WatchlistEntry
+	err := scanWatchlistEntry(watchlistModel.DBPtr.QueryRowContext(ctx, query, userID, movieID), &entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_pkey"`:
+			return nil, ErrDuplicateWatchlistEntry
+		// the movie was purged from the trash while we were inserting
+		case err.Error() == `pq: insert or update on table "watchlist" violates foreign key constraint "watchlist_movie_id_fkey"`:
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &entry, nil
+}
+
+/*
+SET WATCHED - Mark a movie on a user's watchlist as watched or unwatched and return the entry like AddEntry. A movie
+in the trash isn't on the watchlist as far as the user can tell (GetAllForUser leaves it out) until it is restored.
+*/
+func (watchlistModel WatchlistModel) SetWatched(userID, movieID int64, watched bool) (*WatchlistEntry, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		WITH updated AS (
+			UPDATE watchlist
+			SET watched = $1
+			WHERE user_id = $2 AND movie_id = $3
+			AND EXISTS (SELECT 1 FROM movies WHERE id = $3 AND deleted_at IS NULL)
+			RETURNING movie_id, added_at, watched
+		)
+		%s
+	`, watchlistEntryQuery("updated"))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	var entry WatchlistEntry
+	err := scanWatchlistEntry(watchlistModel.DBPtr.QueryRowContext(ctx, query, watched, userID, movieID), &entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &entry, nil
+}
+
+// watchlistEntryQuery selects the watchlist entry a statement returned (movie_id, added_at and watched) from the CTE
+// cte, joined with the movie's columns GetAllForUser lists
+func watchlistEntryQuery(cte string) string {
+	return fmt.Sprintf(`
+		SELECT %[1]s.added_at, %[1]s.watched,
+		movies.id, movies.title, movies.year, movies.runtime, movies.genres, movies.version
+		FROM %[1]s
+		INNER JOIN movies ON movies.id = %[1]s.movie_id
+	`, cte)
+}
+
+func scanWatchlistEntry(rowPtr *sql.Row, entryPtr *WatchlistEntry) error {
+	return rowPtr.Scan(
+		&entryPtr.AddedAt, &entryPtr.Watched,
+		&entryPtr.Movie.ID, &entryPtr.Movie.Title, &entryPtr.Movie.Year, &entryPtr.Movie.Runtime,
+		pq.Array(&entryPtr.Movie.Genres), &entryPtr.Movie.Version,
+	)
+}
+
+/*
+REMOVE ENTRY - Remove a movie from a user's watchlist, unless the movie is in the trash, see SetWatched
+*/
+func (watchlistModel WatchlistModel) RemoveEntry(userID, movieID int64) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2
+		AND EXISTS (SELECT 1 FROM movies WHERE id = $2 AND deleted_at IS NULL)
+	`
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	result, err := watchlistModel.DBPtr.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+/*
+GET ALL ENTRIES FOR A USER - paginated and sorted with the same Filters we use for movies. Passing a nil watched
+returns both watched and unwatched entries.
+*/
+func (watchlistModel WatchlistModel) GetAllForUser(userID int64, watched *bool, filters Filters) ([]*WatchlistEntry, PageMetadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), watchlist.added_at, watchlist.watched,
+		movies.id, movies.title, movies.year, movies.runtime, movies.genres, movies.version
+		FROM watchlist
+		INNER JOIN movies ON movies.id = watchlist.movie_id
+		WHERE watchlist.user_id = $1 AND movies.deleted_at IS NULL
+		AND (watchlist.watched = $2 OR $2 IS NULL)
+		ORDER BY %s
+		OFFSET $3 LIMIT $4
+	`, filters.orderBy(filters.sortColumn()))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancelFunc()
+
+	entryRows, err := watchlistModel.DBPtr.QueryContext(ctx, query, userID, watched, filters.offset(), filters.limit())
+	if err != nil {
+		return nil, PageMetadata{}, err
+	}
+	defer entryRows.Close()
+
+	entryPtrs := []*WatchlistEntry{}
+	for entryRows.Next() {
+		var entry WatchlistEntry
+		err := entryRows.Scan(
+			&entry.TotalEntries, &entry.AddedAt, &entry.Watched,
+			&entry.Movie.ID, &entry.Movie.Title, &entry.Movie.Year, &entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres), &entry.Movie.Version,
+		)
+		if err != nil {
+			return nil, PageMetadata{}, err
+		}
+		entryPtrs = append(entryPtrs, &entry)
+	}
+
+	if err := entryRows.Err(); err != nil {
+		return nil, PageMetadata{}, err
+	}
+
+	totalRecords := 0
+	if len(entryPtrs) > 0 {
+		totalRecords = entryPtrs[0].TotalEntries
+	}
+	return entryPtrs, CalculatePageMetadata(totalRecords, filters.PageSize, filters.Page), nil
+}
@@ -11,9 +11,11 @@ EMAIL REGEX
 Declare a regular expression for sanity checking the format of email addresses (we'll
 use this later in the book). This regular expression pattern is taken from
 https://html.spec.whatwg.org/#valid-e-mail-address.
+UUIDRX matches a UUID in its usual textual form e.g. 123e4567-e89b-12d3-a456-426614174000, in either case.
 */
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	UUIDRX  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
 )
 
 /*********************************************************************************************************************/
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    birth_year integer NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS credits (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL,
+    character_name text NOT NULL DEFAULT '',
+    CONSTRAINT credits_movie_id_person_id_role_character_name_key UNIQUE (movie_id, person_id, role, character_name)
+);
+
+ALTER TABLE credits ADD CONSTRAINT credits_role_check CHECK (role IN ('director', 'actor', 'writer'));
+
+CREATE INDEX IF NOT EXISTS credits_person_id_idx ON credits (person_id);
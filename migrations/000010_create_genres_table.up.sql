@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    retired bool NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT genres_name_key UNIQUE (name)
+);
+
+-- Seed the table with the genres that used to be hard-coded in data.AllowedGenres.
+INSERT INTO genres (name)
+VALUES
+    ('adventure'),
+    ('action'),
+    ('animation'),
+    ('romance'),
+    ('comedy'),
+    ('history'),
+    ('drama'),
+    ('sci-fi');
+
+-- Add the permission needed to manage genres.
+INSERT INTO permissions (code)
+VALUES
+    ('genres:write');
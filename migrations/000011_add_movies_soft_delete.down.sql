@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_deleted_at_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_by;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_versions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    edited_by bigint REFERENCES users ON DELETE SET NULL,
+    edited_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Earlier versions of existing movies are gone, but their current version is kept as the start of their history
+INSERT INTO movie_versions (movie_id, version, title, year, runtime, genres, edited_at)
+SELECT id, version, title, year, runtime, genres, created_at FROM movies
+ON CONFLICT DO NOTHING;
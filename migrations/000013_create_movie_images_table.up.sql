@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS movie_images (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    kind text NOT NULL,
+    content_type text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    blob_key text NOT NULL,
+    thumbnail_key text NOT NULL
+);
+
+ALTER TABLE movie_images ADD CONSTRAINT movie_images_kind_check CHECK (kind IN ('poster', 'still'));
+
+CREATE INDEX IF NOT EXISTS movie_images_movie_id_idx ON movie_images (movie_id);
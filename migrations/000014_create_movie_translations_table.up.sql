@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS movie_translations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    language text NOT NULL,
+    title text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, language)
+);
+
+-- the title search looks through the translated titles too, see MovieQuery.conditions
+CREATE INDEX IF NOT EXISTS movie_translations_title_idx ON movie_translations USING GIN (to_tsvector('simple', title));
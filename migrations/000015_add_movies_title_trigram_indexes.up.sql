@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- these back the typo tolerant ?fuzzy=true title search and the did_you_mean suggestions
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS movie_translations_title_trgm_idx ON movie_translations USING GIN (title gin_trgm_ops);
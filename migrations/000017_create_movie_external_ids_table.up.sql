@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS movie_external_ids (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    source text NOT NULL,
+    external_id text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- an id belongs to one movie, and a movie has one id per source
+    PRIMARY KEY (source, external_id),
+    UNIQUE (movie_id, source)
+);
+
+ALTER TABLE movie_external_ids ADD CONSTRAINT movie_external_ids_source_check CHECK (source IN ('imdb', 'tmdb'));
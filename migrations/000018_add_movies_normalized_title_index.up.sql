@@ -0,0 +1,7 @@
+-- the title two movies are recognised as duplicates by: lower case, every run of spaces and punctuation turned into a
+-- single space and a leading "the" dropped, so "The Godfather: Part II" and "godfather part ii" are the same title
+CREATE OR REPLACE FUNCTION normalized_title(title text) RETURNS text
+LANGUAGE sql IMMUTABLE STRICT PARALLEL SAFE
+AS $$ SELECT regexp_replace(trim(regexp_replace(lower(title), '[^[:alnum:]]+', ' ', 'g')), '^the ', '') $$;
+
+CREATE INDEX IF NOT EXISTS movies_normalized_title_year_idx ON movies (normalized_title(title), year) WHERE deleted_at IS NULL;
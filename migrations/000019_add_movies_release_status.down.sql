@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS movie_releases;
+
+ALTER TABLE movie_versions DROP COLUMN IF EXISTS status;
+
+-- the old check only allows years that have come, movies that aren't out yet are put down for this year
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+UPDATE movies SET year = date_part('year', now()) WHERE year > date_part('year', now());
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year BETWEEN 1888 AND date_part('year', now()));
+
+DROP INDEX IF EXISTS movies_status_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_status_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS status;
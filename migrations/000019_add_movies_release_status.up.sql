@@ -0,0 +1,20 @@
+-- what stage a movie is at, every movie we had so far has been released
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'released';
+ALTER TABLE movies ADD CONSTRAINT movies_status_check CHECK (status IN ('announced', 'in_production', 'released'));
+CREATE INDEX IF NOT EXISTS movies_status_idx ON movies (status);
+
+-- an announced movie is due out in a year that hasn't come yet, how far ahead it may be is checked by ValidateMovie
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year >= 1888);
+
+ALTER TABLE movie_versions ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'released';
+
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    region text NOT NULL,
+    release_date date NOT NULL,
+    PRIMARY KEY (movie_id, region)
+);
+
+-- ?region= and ?released_after= look releases up by region and date, see MovieQuery.conditions
+CREATE INDEX IF NOT EXISTS movie_releases_region_release_date_idx ON movie_releases (region, release_date);